@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// AggregateChunk merges the chunks of a streamed response into one message per choice.
+type AggregateChunk struct {
+	choices map[int]*choiceAggregate
+}
+
+type choiceAggregate struct {
+	msg          openai.ChatCompletionMessage
+	finishReason openai.FinishReason
+	// toolCalls are kept in the order they first appear, the index of a call is its position in the
+	// response, assigned in order of appearance if the provider omits it.
+	toolCalls []indexedToolCall
+}
+
+type indexedToolCall struct {
+	index int
+	call  openai.ToolCall
+}
+
+func NewAggregateChunk() *AggregateChunk {
+	return &AggregateChunk{choices: make(map[int]*choiceAggregate)}
+}
+
+func (self *AggregateChunk) addChunk(choice openai.ChatCompletionStreamChoice) {
+	agg, exist := self.choices[choice.Index]
+	if !exist {
+		agg = &choiceAggregate{}
+		self.choices[choice.Index] = agg
+	}
+	if choice.FinishReason != "" {
+		agg.finishReason = choice.FinishReason
+	}
+	delta := choice.Delta
+	agg.msg.Content += delta.Content
+	for _, toolCall := range delta.ToolCalls {
+		agg.addToolCall(toolCall)
+	}
+}
+
+// addToolCall merges the tool call delta into the call with the same index. Without an index, a delta
+// with a new id starts a new call and other deltas continue the last call.
+func (agg *choiceAggregate) addToolCall(delta openai.ToolCall) {
+	pos := -1
+	if delta.Index != nil {
+		pos = slices.IndexFunc(agg.toolCalls, func(call indexedToolCall) bool { return call.index == *delta.Index })
+	} else if len(agg.toolCalls) != 0 {
+		last := len(agg.toolCalls) - 1
+		if delta.ID == "" || delta.ID == agg.toolCalls[last].call.ID {
+			pos = last
+		}
+	}
+	if pos < 0 {
+		index := len(agg.toolCalls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		delta.Index = nil
+		agg.toolCalls = append(agg.toolCalls, indexedToolCall{index: index, call: delta})
+		return
+	}
+	call := &agg.toolCalls[pos].call
+	if call.ID == "" {
+		call.ID = delta.ID
+	}
+	if call.Type == "" {
+		call.Type = delta.Type
+	}
+	call.Function.Name += delta.Function.Name
+	call.Function.Arguments += delta.Function.Arguments
+}
+
+func (agg *choiceAggregate) res() openai.ChatCompletionMessage {
+	msg := agg.msg
+	msg.Role = "assistant"
+	calls := slices.Clone(agg.toolCalls)
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].index < calls[j].index })
+	for _, indexed := range calls {
+		call := indexed.call
+		if call.Type == "" {
+			call.Type = openai.ToolTypeFunction
+		}
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	return msg
+}
+
+// res returns the message of the first choice, the only one unless the request asks for more.
+func (self *AggregateChunk) res() openai.ChatCompletionMessage {
+	msgs, _ := self.choiceResults()
+	if len(msgs) == 0 {
+		return openai.ChatCompletionMessage{Role: "assistant"}
+	}
+	return msgs[0]
+}
+
+// finishReason returns the finish reason of the first choice.
+func (self *AggregateChunk) finishReason() openai.FinishReason {
+	_, reasons := self.choiceResults()
+	if len(reasons) == 0 {
+		return ""
+	}
+	return reasons[0]
+}
+
+// choiceResults returns the message and the finish reason of every choice in choice index order.
+func (self *AggregateChunk) choiceResults() ([]openai.ChatCompletionMessage, []openai.FinishReason) {
+	msgs := []openai.ChatCompletionMessage{}
+	reasons := []openai.FinishReason{}
+	for _, index := range slices.Sorted(maps.Keys(self.choices)) {
+		msgs = append(msgs, self.choices[index].res())
+		reasons = append(reasons, self.choices[index].finishReason)
+	}
+	return msgs, reasons
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// replaySSE streams the recorded SSE file through go-openai and aggregates every chunk.
+func replaySSE(t *testing.T, name string) *AggregateChunk {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "sse", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	defer server.Close()
+	cfg := openai.DefaultConfig("sk-test")
+	cfg.BaseURL = server.URL
+	stream, err := openai.NewClientWithConfig(cfg).CreateChatCompletionStream(t.Context(), openai.ChatCompletionRequest{Model: chatModel, Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	aggregate := NewAggregateChunk()
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return aggregate
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, choice := range res.Choices {
+			aggregate.addChunk(choice)
+		}
+	}
+}
+
+func toolCall(id string, name string, args string) openai.ToolCall {
+	return openai.ToolCall{ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: args}}
+}
+
+func TestAggregateChunk_replay(t *testing.T) {
+	tests := []struct {
+		file        string
+		wantMsgs    []openai.ChatCompletionMessage
+		wantReasons []openai.FinishReason
+	}{
+		{
+			file: "parallel_tool_calls.sse",
+			wantMsgs: []openai.ChatCompletionMessage{{
+				Role:    "assistant",
+				Content: "Let me look.",
+				ToolCalls: []openai.ToolCall{
+					toolCall("call_a", "read_file", `{"file":"README.md","start_line":1}`),
+					toolCall("call_b", "find_reference", `{"file":"main.go","name":"main","line":3}`),
+				},
+			}},
+			wantReasons: []openai.FinishReason{openai.FinishReasonToolCalls},
+		},
+		{
+			file: "missing_index.sse",
+			wantMsgs: []openai.ChatCompletionMessage{{
+				Role: "assistant",
+				ToolCalls: []openai.ToolCall{
+					toolCall("call_1", "search_symbol", `{"query":"Agent","kind":"type","package":""}`),
+					toolCall("call_2", "get_directory_overview", `{"path":"agent"}`),
+				},
+			}},
+			wantReasons: []openai.FinishReason{openai.FinishReasonToolCalls},
+		},
+		{
+			file: "multiple_choices.sse",
+			wantMsgs: []openai.ChatCompletionMessage{
+				{Role: "assistant", Content: "First answer."},
+				{Role: "assistant", Content: "Second answer, cut"},
+			},
+			wantReasons: []openai.FinishReason{openai.FinishReasonStop, openai.FinishReasonLength},
+		},
+		{
+			file:        "text_only.sse",
+			wantMsgs:    []openai.ChatCompletionMessage{{Role: "assistant", Content: "The agent loops over turns."}},
+			wantReasons: []openai.FinishReason{openai.FinishReasonStop},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			// the result does not depend on map iteration order
+			for range 10 {
+				aggregate := replaySSE(t, tt.file)
+				msgs, reasons := aggregate.choiceResults()
+				if !reflect.DeepEqual(msgs, tt.wantMsgs) {
+					t.Fatalf("messages = %+v\nwant %+v", msgs, tt.wantMsgs)
+				}
+				if !reflect.DeepEqual(reasons, tt.wantReasons) {
+					t.Fatalf("finish reasons = %v, want %v", reasons, tt.wantReasons)
+				}
+				if !reflect.DeepEqual(aggregate.res(), tt.wantMsgs[0]) || aggregate.finishReason() != tt.wantReasons[0] {
+					t.Fatalf("first choice = %+v %s", aggregate.res(), aggregate.finishReason())
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateChunk_empty(t *testing.T) {
+	aggregate := NewAggregateChunk()
+	if msg := aggregate.res(); msg.Role != "assistant" || msg.Content != "" || len(msg.ToolCalls) != 0 {
+		t.Errorf("res() = %+v, want an empty assistant message", msg)
+	}
+	if reason := aggregate.finishReason(); reason != "" {
+		t.Errorf("finishReason() = %q, want empty", reason)
+	}
+}
@@ -11,21 +11,37 @@ import (
 	ctx "llm_dev/context"
 	"llm_dev/database"
 	"llm_dev/model"
-	"os"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 )
 
+const (
+	chatModel      = "openrouter/anthropic/claude-sonnet-4"
+	embeddingModel = "text-embedding-3-small"
+	// maxTruncatedResponses is the number of truncated responses continued in a task before it is aborted.
+	maxTruncatedResponses = 3
+)
+
 type Model struct {
 	*openai.Client
 	apikey  string
 	baseUrl string
 }
 
+// Embedder returns the embedder of the semantic search index.
+func (m *Model) Embedder() model.Embedder {
+	return model.NewOpenAIEmbedder(m.Client, embeddingModel)
+}
+
 func NewModel(baseurl string, apikey string) *Model {
 	cfg := openai.DefaultConfig(apikey)
 	cfg.BaseURL = baseurl
+	cfg.HTTPClient = model.NewRetryAfterDoer(model.NewCacheControlDoer(&http.Client{}))
 	return &Model{
 		Client:  openai.NewClientWithConfig(cfg),
 		apikey:  apikey,
@@ -36,63 +52,85 @@ func NewModel(baseurl string, apikey string) *Model {
 type AgentContext struct {
 	userPrompt string
 	history    []openai.ChatCompletionMessage
-	finished   bool
 
 	preTaskHistory []openai.ChatCompletionMessage
 
 	ctxMgr []ctx.ContextMgr
+	budget ctx.ContextBudget
+	usage  ctx.ContextUsage
+	// cacheBreakpoints are the indexes of the request messages ending a cacheable prefix.
+	cacheBreakpoints []int
+	tokenUsage       TokenUsage
+	mode             ctx.Mode
+
+	iterations   int
+	toolCalls    int
+	finishReason openai.FinishReason
+	// truncated counts the responses cut off at the max output tokens.
+	truncated int
+	// streamed is the response text of the turn sent as TextDeltaEvents, see textStream.
+	streamed string
 
 	toolHandlerMap map[string]model.ToolDef
+	// emit reports the events of the task, it is only called on the goroutine running the task.
+	emit func(Event)
 }
 
 func NewAgentContext(preHistory []openai.ChatCompletionMessage, userprompt string, ctxMgr ...ctx.ContextMgr) *AgentContext {
 	ctx := AgentContext{
 		userPrompt:     userprompt,
-		finished:       false,
 		toolHandlerMap: make(map[string]model.ToolDef),
 		preTaskHistory: preHistory,
 		ctxMgr:         ctxMgr,
+		budget:         ctx.NewContextBudget(0),
+		emit:           func(Event) {},
 	}
 	for _, mgr := range ctxMgr {
 		ctx.registerTool(mgr.GetToolDef())
 	}
 	return &ctx
 }
-func (ctx *AgentContext) getResult() []openai.ChatCompletionMessage {
-	usermsg := openai.ChatCompletionMessage{
-		Role:    "user",
-		Content: ctx.userPrompt,
-	}
-	res := []openai.ChatCompletionMessage{usermsg}
-	historyLen := len(ctx.history)
-	if historyLen != 0 {
-		res = append(res, ctx.history[historyLen-1])
-	}
-	return res
-}
+
+// genRequest lays out the request for prompt caching, the stable prefix comes first and the volatile
+// context last: the system message holds the system prompt and the stable context of every manager,
+// followed by the conversation history, and the rendered context is sent as the last message.
+// Cache breakpoints are set on the system message and the last history message.
 func (ctx *AgentContext) genRequest(sysPrompt string) openai.ChatCompletionRequest {
 	req := openai.ChatCompletionRequest{
-		Model:  "openrouter/anthropic/claude-sonnet-4",
+		Model:  chatModel,
 		Stream: true,
+		StreamOptions: &openai.StreamOptions{
+			IncludeUsage: true,
+		},
 	}
 
+	var stable bytes.Buffer
+	stable.WriteString(sysPrompt)
+	writeStableContext(&stable, ctx.ctxMgr)
 	var buf bytes.Buffer
-	buf.WriteString(sysPrompt)
-	ctx.writeContext(&buf)
+	buf.WriteString(volatileContextHeader)
+	ctx.usage = ctx.budget.WriteContext(&buf, ctx.ctxMgr, ctx.budget.Count(stable.String()))
 	req.Messages = []openai.ChatCompletionMessage{}
 	sysmsg := openai.ChatCompletionMessage{
 		Role:    "system",
-		Content: buf.String(),
+		Content: stable.String(),
 	}
 	usermsg := openai.ChatCompletionMessage{
 		Role:    "user",
 		Content: ctx.userPrompt,
 	}
+	ctxmsg := openai.ChatCompletionMessage{
+		Role:    "user",
+		Content: buf.String(),
+	}
 	req.Messages = append(req.Messages, sysmsg)
 	req.Messages = append(req.Messages, ctx.preTaskHistory...)
 	req.Messages = append(req.Messages, usermsg)
 	req.Messages = append(req.Messages, ctx.history...)
-	for _, tool := range ctx.toolHandlerMap {
+	ctx.cacheBreakpoints = []int{0, len(req.Messages) - 1}
+	req.Messages = append(req.Messages, ctxmsg)
+	for _, name := range slices.Sorted(maps.Keys(ctx.toolHandlerMap)) {
+		tool := ctx.toolHandlerMap[name]
 		req.Tools = append(req.Tools, openai.Tool{
 			Type:     openai.ToolTypeFunction,
 			Function: &tool.FunctionDefinition,
@@ -100,33 +138,56 @@ func (ctx *AgentContext) genRequest(sysPrompt string) openai.ChatCompletionReque
 	}
 	return req
 }
+func writeStableContext(buf *bytes.Buffer, mgrs []ctx.ContextMgr) {
+	for _, mgr := range mgrs {
+		if stableMgr, ok := mgr.(ctx.StableContextMgr); ok {
+			stableMgr.WriteStableContext(buf)
+		}
+	}
+}
+
 func (ctx *AgentContext) addMessage(msg openai.ChatCompletionMessage) {
 	ctx.history = append(ctx.history, msg)
 }
-func (ctx *AgentContext) done() bool {
-	return ctx.finished
-}
-func (ctx *AgentContext) writeContext(buf *bytes.Buffer) {
-	for _, mgr := range ctx.ctxMgr {
-		mgr.WriteContext(buf)
-	}
-}
 
+// toolCall runs the tool call and always returns the tool message, if the call fails the message
+// holds the ToolError also returned as the error.
 func (ctx *AgentContext) toolCall(toolCall openai.ToolCall) (openai.ChatCompletionMessage, error) {
 	res := openai.ChatCompletionMessage{
 		Role:       "tool",
 		ToolCallID: toolCall.ID,
 	}
+	resStr, err := ctx.runTool(toolCall.Function)
+	if err != nil {
+		res.Content = err.content()
+		return res, err
+	}
+	res.Content = resStr
+	return res, nil
+}
 
-	def, exist := ctx.toolHandlerMap[toolCall.Function.Name]
+func (ctx *AgentContext) runTool(call openai.FunctionCall) (res string, toolErr *ToolError) {
+	def, exist := ctx.toolHandlerMap[call.Name]
 	if !exist {
-		return res, fmt.Errorf("%s tool does not exist", toolCall.Function.Name)
+		return "", &ToolError{
+			Type:    ToolNotFound,
+			Tool:    call.Name,
+			Message: fmt.Sprintf("%s tool does not exist in %s mode, the available tools are %v", call.Name, ctx.mode, slices.Sorted(maps.Keys(ctx.toolHandlerMap))),
+		}
+	}
+	if err := def.ValidateArgs(call.Arguments); err != nil {
+		return "", &ToolError{Type: InvalidArguments, Tool: call.Name, Message: err.Error()}
 	}
-	resStr, err := def.Handler(toolCall.Function.Arguments)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Any("panic", r).Str("tool", call.Name).Msg("tool handler panicked")
+			toolErr = &ToolError{Type: ToolFailed, Tool: call.Name, Message: fmt.Sprintf("the tool crashed: %v", r)}
+		}
+	}()
+	res, err := def.Handler(call.Arguments)
 	if err != nil {
-		return res, err
+		return "", &ToolError{Type: ToolFailed, Tool: call.Name, Message: err.Error()}
 	}
-	res.Content = resStr
 	return res, nil
 }
 
@@ -145,125 +206,300 @@ type BaseAgent struct {
 	model   Model
 	root    string
 	buildOp *impl.BuildCodeBaseCtxOps
+	cfg     AgentConfig
+
+	history    []TaskMemory
+	summarizer Summarizer
+
+	// ctxMgrs live as long as the agent, so the loaded context is kept across tasks and saved with the session.
+	ctxMgrs []ctx.NamedMgr
+	// fileCtx is the file content manager in ctxMgrs, nil if the profile disables it.
+	fileCtx *ctx.FileContentCtxMgr
+	mode    ctx.Mode
+
+	session *Session
+	store   *SessionStore
 
-	history []openai.ChatCompletionMessage
+	onEvent EventHandler
 }
 
 func NewBaseAgent(codebase string, model Model) BaseAgent {
 	agent := BaseAgent{
-		model: model,
-		root:  codebase,
+		model:      model,
+		root:       codebase,
+		cfg:        DefaultAgentConfig(),
+		mode:       ctx.ModeAsk,
+		summarizer: NewModelSummarizer(model.Client, chatModel),
 		buildOp: &impl.BuildCodeBaseCtxOps{
 			RootPath: codebase,
 			Db:       database.GetDBClient().Database("llm_dev"),
 		},
 	}
+	if err := agent.newCtxMgrs(); err != nil {
+		log.Error().Err(err).Msg("create context managers fail")
+	}
 	return agent
 }
 
-type AggregateChunk struct {
-	msg       openai.ChatCompletionMessage
-	toolCalls map[int]openai.ToolCall
-}
-
-func (self *AggregateChunk) addChunk(delta openai.ChatCompletionStreamChoiceDelta) {
-	if delta.Content != "" {
-		self.msg.Content += delta.Content
+// newCtxMgrs creates the context managers of the active profile from the registry.
+func (agent *BaseAgent) newCtxMgrs() error {
+	profile, err := agent.cfg.ActiveProfile()
+	if err != nil {
+		return err
 	}
-	for _, toolCall := range delta.ToolCalls {
-		index := *toolCall.Index
-		value, exist := self.toolCalls[index]
-		if !exist {
-			value = toolCall
-		} else {
-			value.Function.Arguments += toolCall.Function.Arguments
+	env := ctx.FactoryEnv{
+		Root:     agent.root,
+		BuildOp:  agent.buildOp,
+		Embedder: agent.model.Embedder(),
+	}
+	mgrs, err := ctx.NewMgrs(env, profile.Context)
+	if err != nil {
+		return err
+	}
+	agent.ctxMgrs = mgrs
+	agent.fileCtx = nil
+	for _, named := range mgrs {
+		if fileCtx, ok := named.Mgr.(*ctx.FileContentCtxMgr); ok {
+			agent.fileCtx = fileCtx
 		}
-		self.toolCalls[index] = value
 	}
+	return nil
 }
-func (self *AggregateChunk) res() openai.ChatCompletionMessage {
-	self.msg.Role = "assistant"
-	for _, toolcall := range self.toolCalls {
-		self.msg.ToolCalls = append(self.msg.ToolCalls, toolcall)
+
+func (agent *BaseAgent) contextMgrs() []ctx.ContextMgr {
+	res := make([]ctx.ContextMgr, len(agent.ctxMgrs))
+	for i, named := range agent.ctxMgrs {
+		res[i] = named.Mgr
 	}
-	return self.msg
+	return res
 }
 
-func (agent *BaseAgent) handleResponse(stream *openai.ChatCompletionStream, ctx *AgentContext) {
-	var err error
-	aggregate := AggregateChunk{
-		toolCalls: make(map[int]openai.ToolCall),
+// ContextMgrs returns the context managers with the tools of the current mode, for the front-ends
+// serving the tools to other agents without the agent loop.
+func (agent *BaseAgent) ContextMgrs() []ctx.NamedMgr {
+	agent.applyMode()
+	return agent.ctxMgrs
+}
+
+// SetConfig replaces the config and recreates the context managers for its profile,
+// it must be called before a session is started or resumed.
+func (agent *BaseAgent) SetConfig(cfg AgentConfig) error {
+	agent.cfg = cfg
+	if err := agent.newCtxMgrs(); err != nil {
+		return err
 	}
-	var finishReason openai.FinishReason
-	fmt.Printf("RESP:\n")
+	return agent.SetMode(cfg.Mode)
+}
+
+// recvResponse reads the streamed response into one message, it returns the error if the stream fails
+// or ends before the response is complete, the message then holds the partial response.
+func (agent *BaseAgent) recvResponse(stream *openai.ChatCompletionStream, ctx *AgentContext) (openai.ChatCompletionMessage, openai.FinishReason, error) {
+	var err error
+	aggregate := NewAggregateChunk()
+	hasUsage := false
+	text := textStream{ctx: ctx, shown: ctx.streamed}
 	for {
 		res, e := stream.Recv()
 		if e != nil {
 			err = e
 			break
 		}
-		finishReason = res.Choices[0].FinishReason
-		d := res.Choices[0].Delta
-		if d.Content != "" {
-			fmt.Print(d.Content)
+		// with include_usage the last chunk carries the usage and no choice
+		if res.Usage != nil {
+			hasUsage = true
+			ctx.tokenUsage.add(res.Usage)
+			ctx.emit(UsageEvent{Usage: ctx.tokenUsage})
+		}
+		for _, choice := range res.Choices {
+			if choice.Index == 0 && choice.Delta.Content != "" {
+				text.add(choice.Delta.Content)
+			}
+			aggregate.addChunk(choice)
 		}
-		aggregate.addChunk(d)
 	}
-	fmt.Print("END OF RESP\n\n")
 	if !errors.Is(err, io.EOF) {
-		ctx.finished = true
-		return
-	}
-	file, _ := os.OpenFile("context.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	resp := aggregate.res()
-	file.WriteString(fmt.Sprintf("RESP:\n%s\n\n", resp.Content))
-	ctx.addMessage(resp)
-	for _, toolCall := range resp.ToolCalls {
-		msg, err := ctx.toolCall(toolCall)
-		file.WriteString(fmt.Sprintf("TOOL CALL:\n%s\n", msg.Content))
+		text.end(false)
+		return aggregate.res(), "", err
+	}
+	finishReason := aggregate.finishReason()
+	// go-openai reports a stream closed before [DONE] as io.EOF too, a complete response ends with the
+	// finish reason or at least the usage chunk
+	if finishReason == "" && !hasUsage {
+		text.end(false)
+		return aggregate.res(), "", errTruncatedStream
+	}
+	text.end(true)
+	return aggregate.res(), finishReason, nil
+}
+
+// handleResponse commits the complete response to the history and runs its tool calls.
+func (agent *BaseAgent) handleResponse(ctx *AgentContext, resp openai.ChatCompletionMessage, finishReason openai.FinishReason) {
+	ctx.finishReason = finishReason
+	if finishReason == openai.FinishReasonLength {
+		// the arguments of the tool calls in a truncated response may be incomplete
+		resp.ToolCalls = nil
+	}
+	ctx.emit(ResponseFinishedEvent{Message: resp, FinishReason: finishReason})
+	if resp.Content != "" || len(resp.ToolCalls) != 0 {
+		ctx.addMessage(resp)
+	}
+	calls := resp.ToolCalls
+	if limit := agent.cfg.MaxToolCalls; limit > 0 && len(calls) > limit-ctx.toolCalls {
+		calls = calls[:max(limit-ctx.toolCalls, 0)]
+	}
+	results := ctx.runToolCalls(calls, agent.cfg.ToolConcurrency)
+	for i, toolCall := range calls {
+		ctx.toolCalls++
+		msg, err := results[i].msg, results[i].err
 		if err != nil {
 			log.Error().Err(err).Any("toolcall", toolCall).Msg("tool call failed")
 		} else {
 			log.Info().Any("tool call", toolCall).Any("result", msg.Content).Msg("run tool call success")
-			ctx.addMessage(msg)
 		}
+		ctx.addMessage(msg)
 	}
-	var buf bytes.Buffer
-	ctx.writeContext(&buf)
-	file.WriteString("CONTEXT:\n")
-	file.Write(buf.Bytes())
-	file.Close()
+	// the calls over the budget are not run, they still need a tool message
+	for _, toolCall := range resp.ToolCalls[len(calls):] {
+		err := &ToolError{
+			Type:    ToolBudgetExhausted,
+			Tool:    toolCall.Function.Name,
+			Message: fmt.Sprintf("the task reached the max %d tool calls, the call is not run", agent.cfg.MaxToolCalls),
+		}
+		log.Warn().Any("toolcall", toolCall).Msg("tool call over the budget")
+		ctx.addMessage(openai.ChatCompletionMessage{Role: "tool", ToolCallID: toolCall.ID, Content: err.content()})
+	}
+	ctx.fileChanged()
+}
+
+// requestTurn sends one request and reads the response, the stream is closed before it returns.
+// retryAfter is set to the delay asked by the Retry-After header of a failed response.
+func (agent *BaseAgent) requestTurn(taskCtx context.Context, ctx *AgentContext, sysPrompt string, retryAfter *time.Duration) (openai.ChatCompletionMessage, openai.FinishReason, error) {
+	req := ctx.genRequest(sysPrompt)
+	ctx.emit(ContextRenderedEvent{Turn: ctx.iterations, Context: req.Messages[len(req.Messages)-1].Content, Usage: ctx.usage})
+	reqCtx, delay := model.WithRetryAfter(taskCtx)
+	if agent.cfg.PromptCache {
+		reqCtx = model.WithCacheBreakpoints(reqCtx, ctx.cacheBreakpoints)
+	}
+	defer func() { *retryAfter = *delay }()
+	stream, err := agent.model.CreateChatCompletionStream(reqCtx, req)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, "", err
+	}
+	defer stream.Close()
+	return agent.recvResponse(stream, ctx)
+}
 
-	if finishReason == openai.FinishReasonStop {
-		ctx.finished = true
+// runTurn runs one turn of the task. Nothing is committed to the history until the response is complete,
+// so a failed request is retried from the same history with backoff. When taskCtx is canceled the partial
+// response is kept in the history marked as interrupted.
+func (agent *BaseAgent) runTurn(taskCtx context.Context, ctx *AgentContext, sysPrompt string) error {
+	ctx.iterations++
+	ctx.streamed = ""
+	ctx.emit(TurnStartedEvent{Turn: ctx.iterations})
+	retry := agent.cfg.Retry
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		resp, finishReason, err := agent.requestTurn(taskCtx, ctx, sysPrompt, &retryAfter)
+		if err == nil {
+			agent.handleResponse(ctx, resp, finishReason)
+			return nil
+		}
+		if taskCtx.Err() != nil {
+			if resp.Content != "" {
+				ctx.addMessage(openai.ChatCompletionMessage{Role: "assistant", Content: resp.Content + interruptedMark})
+			}
+			return taskCtx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+		if attempt >= retry.MaxRetries {
+			return fmt.Errorf("the request still failed after %d retries: %w", attempt, err)
+		}
+		delay := retry.delay(attempt, retryAfter)
+		log.Warn().Err(err).Int("attempt", attempt+1).Dur("delay", delay).Msg("chat completion failed, retry")
+		ctx.emit(ErrorEvent{Err: err, Message: err.Error(), Retry: attempt + 1, Delay: delay})
+		select {
+		case <-time.After(delay):
+		case <-taskCtx.Done():
+			return taskCtx.Err()
+		}
 	}
 }
 
-func (agent *BaseAgent) NewUserTask(userprompt string) {
-	callGraphMgr := ctx.NewCallGraphMgr(agent.root, agent.buildOp)
-	filectxMgr := ctx.NewFileCtxMgr(agent.root, agent.buildOp)
-	outlineCtxMgr := ctx.NewOutlineCtxMgr(agent.root, agent.buildOp)
-	buildContextMgr := ctx.BuildContextMgr{}
-	outlineCtxMgr.OpenDir(".")
-	ctx := NewAgentContext(agent.history, userprompt, &callGraphMgr, &outlineCtxMgr, &buildContextMgr, &filectxMgr)
+// runTask runs the turns of the task until the model stops, the response can not be used, the budget is
+// exhausted or taskCtx is canceled.
+func (agent *BaseAgent) runTask(taskCtx context.Context, ctx *AgentContext, sysPrompt string) TaskOutcome {
 	for {
-		// var buf bytes.Buffer
-		// // ctx.fileCtxMgr.WriteUsedDefs(&buf)
-		// ctx.fileCtxMgr.WriteAutoLoadCtx(&buf)
-		// fmt.Print(buf.String())
-		req := ctx.genRequest(systemPompt)
-		stream, err := agent.model.CreateChatCompletionStream(context.TODO(), req)
+		if taskCtx.Err() != nil {
+			return TaskOutcome{Status: OutcomeAborted, Reason: "interrupted by the user"}
+		}
+		if limit := agent.cfg.MaxIterations; limit > 0 && ctx.iterations >= limit {
+			return TaskOutcome{Status: OutcomeBudgetExhausted, Reason: fmt.Sprintf("reached the max %d iterations", limit)}
+		}
+		if limit := agent.cfg.MaxToolCalls; limit > 0 && ctx.toolCalls >= limit {
+			return TaskOutcome{Status: OutcomeBudgetExhausted, Reason: fmt.Sprintf("reached the max %d tool calls", limit)}
+		}
+		err := agent.runTurn(taskCtx, ctx, sysPrompt)
+		if taskCtx.Err() != nil {
+			return TaskOutcome{Status: OutcomeAborted, Reason: "interrupted by the user"}
+		}
 		if err != nil {
-			log.Error().Err(err).Msg("create chat completion stream failed")
-			break
+			log.Error().Err(err).Msg("chat completion failed")
+			ctx.emit(ErrorEvent{Err: err, Message: err.Error()})
+			return TaskOutcome{Status: OutcomeError, Reason: "chat completion failed", Err: err, Error: err.Error()}
 		}
-		defer stream.Close()
-		agent.handleResponse(stream, ctx)
-		if ctx.done() {
-			break
+		ctx.turnEnd()
+		switch ctx.finishReason {
+		case openai.FinishReasonStop:
+			return TaskOutcome{Status: OutcomeCompleted, Reason: string(ctx.finishReason)}
+		case openai.FinishReasonContentFilter:
+			return TaskOutcome{Status: OutcomeAborted, Reason: "the response is blocked by the content filter"}
+		case openai.FinishReasonLength:
+			ctx.truncated++
+			if ctx.truncated > maxTruncatedResponses {
+				return TaskOutcome{Status: OutcomeAborted, Reason: fmt.Sprintf("the response is truncated at the max output tokens %d times", ctx.truncated)}
+			}
+			ctx.addMessage(openai.ChatCompletionMessage{Role: "user", Content: truncatedPrompt})
+		case openai.FinishReasonToolCalls, openai.FinishReasonFunctionCall:
+		default:
+			// some providers send no finish reason, the task goes on only if the model called tools
+			if len(ctx.history) == 0 || ctx.history[len(ctx.history)-1].Role != "tool" {
+				return TaskOutcome{Status: OutcomeCompleted, Reason: "no tool call"}
+			}
 		}
 	}
-	agent.history = append(agent.history, ctx.getResult()...)
+}
+
+// NewUserTask runs the user task to the end and returns how it ended. Canceling taskCtx interrupts the
+// task, the turns finished before are kept in the history and the session.
+func (agent *BaseAgent) NewUserTask(taskCtx context.Context, userprompt string) TaskOutcome {
+	ctx, outcome := agent.startTask(taskCtx, agent.historyMessages(), userprompt, systemPrompt(agent.mode))
+	agent.memorize(taskCtx, ctx, outcome)
+	agent.saveSession()
+	agent.emit(TaskFinishedEvent{Outcome: outcome})
+	return outcome
+}
+
+// startTask runs the user prompt after the history messages with the context managers of the agent.
+func (agent *BaseAgent) startTask(taskCtx context.Context, history []openai.ChatCompletionMessage, userprompt string, sysPrompt string) (*AgentContext, TaskOutcome) {
+	if agent.fileCtx != nil {
+		agent.fileCtx.MaxAge = agent.cfg.ContextMaxAge
+	}
+	agent.applyMode()
+	budget := ctx.NewContextBudget(agent.cfg.ContextTokens)
+	ctx := NewAgentContext(history, userprompt, agent.contextMgrs()...)
+	ctx.budget = budget
+	ctx.mode = agent.mode
+	ctx.emit = agent.emit
+	ctx.taskStart()
+	outcome := agent.runTask(taskCtx, ctx, sysPrompt)
+	ctx.taskEnd()
+	outcome.Answer = finalAnswer(ctx.history)
+	outcome.Iterations = ctx.iterations
+	outcome.ToolCalls = ctx.toolCalls
+	outcome.Usage = ctx.tokenUsage
+	return ctx, outcome
 }
 
 func DebugMsg(msg *openai.ChatCompletionRequest) {
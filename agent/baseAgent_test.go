@@ -5,12 +5,19 @@ import (
 	"llm_dev/codebase/impl"
 	"llm_dev/context"
 	"llm_dev/database"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/sashabaranov/go-openai"
 )
 
 func TestSysPrompt(t *testing.T) {
 	t.Run("teest system prompt format", func(t *testing.T) {
-		fmt.Print(systemPompt)
+		for _, mode := range context.Modes {
+			fmt.Print(systemPrompt(mode))
+		}
 	})
 }
 func TestBaseAgent_genRequest(t *testing.T) {
@@ -29,7 +36,7 @@ func TestBaseAgent_genRequest(t *testing.T) {
 			fmt.Print("User Prompt >")
 			fmt.Scanln(&userPrompt)
 
-			agent.NewUserTask(userPrompt)
+			agent.NewUserTask(t.Context(), userPrompt)
 		}
 	})
 }
@@ -52,3 +59,56 @@ func TestTool(t *testing.T) {
 		DebugMsg(&test)
 	})
 }
+
+func TestAgentContext_genRequestStable(t *testing.T) {
+	root := t.TempDir()
+	buildOp := &impl.BuildCodeBaseCtxOps{RootPath: root}
+	newCtx := func() *AgentContext {
+		fileMgr := context.NewFileCtxMgr(root, buildOp)
+		searchMgr := context.NewSearchCtxMgr(root, buildOp, nil)
+		return NewAgentContext(nil, "hello", &fileMgr, &searchMgr)
+	}
+	want := newCtx().genRequest(systemPrompt(context.ModeAsk))
+	names := []string{}
+	for _, tool := range want.Tools {
+		names = append(names, tool.Function.Name)
+	}
+	if !slices.IsSorted(names) {
+		t.Errorf("tools are not sorted by name: %v", names)
+	}
+	for i := 0; i < 10; i++ {
+		got := newCtx().genRequest(systemPrompt(context.ModeAsk))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("genRequest is not deterministic")
+		}
+	}
+}
+
+func TestAgentContext_genRequestLayout(t *testing.T) {
+	root := t.TempDir()
+	buildOp := &impl.BuildCodeBaseCtxOps{RootPath: root}
+	fileMgr := context.NewFileCtxMgr(root, buildOp)
+	buildMgr := context.BuildContextMgr{}
+	agentCtx := NewAgentContext(nil, "hello", &fileMgr, &buildMgr)
+
+	first := agentCtx.genRequest(systemPrompt(context.ModeAsk))
+	agentCtx.addMessage(openai.ChatCompletionMessage{Role: "assistant", Content: "hi"})
+	second := agentCtx.genRequest(systemPrompt(context.ModeAsk))
+
+	if first.Messages[0].Content != second.Messages[0].Content {
+		t.Errorf("system message changed between turns")
+	}
+	if !strings.Contains(second.Messages[0].Content, "Good workflow examples") {
+		t.Errorf("system message does not contain the stable context")
+	}
+	last := second.Messages[len(second.Messages)-1]
+	if !strings.HasPrefix(last.Content, volatileContextHeader) || !strings.Contains(last.Content, "CODEBASE LOADED FILE CONTEXT") {
+		t.Errorf("last message is not the volatile context: %s", last.Content)
+	}
+	if want := []int{0, len(second.Messages) - 2}; !slices.Equal(agentCtx.cacheBreakpoints, want) {
+		t.Errorf("cacheBreakpoints = %v, want %v", agentCtx.cacheBreakpoints, want)
+	}
+	if second.Messages[len(second.Messages)-2].Content != "hi" {
+		t.Errorf("the last cache breakpoint is not the last history message")
+	}
+}
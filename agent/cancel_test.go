@@ -0,0 +1,81 @@
+package agent
+
+import (
+	stdcontext "context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBaseAgent_NewUserTaskCanceled(t *testing.T) {
+	tests := []struct {
+		name         string
+		response     func(cancel func()) sseResponse
+		wantRequests int
+		wantAnswer   string
+	}{
+		{
+			name: "canceled while streaming",
+			response: func(cancel func()) sseResponse {
+				partial := textResponse("half an answ", "")
+				partial.chunks = partial.chunks[:1]
+				// give the client the time to read the partial response before it is canceled
+				partial.hang = func() {
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+				}
+				return partial
+			},
+			wantRequests: 1,
+			wantAnswer:   "half an answ" + interruptedMark,
+		},
+		{
+			name: "canceled while waiting to retry",
+			response: func(cancel func()) sseResponse {
+				return sseResponse{status: http.StatusServiceUnavailable, hang: cancel}
+			},
+			wantRequests: 1,
+		},
+		{
+			name: "canceled before the task",
+			response: func(cancel func()) sseResponse {
+				cancel()
+				return textResponse("done", openai.FinishReasonStop)
+			},
+			wantRequests: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			taskCtx, cancel := stdcontext.WithCancel(t.Context())
+			defer cancel()
+			model, requests := newFakeModel(t, tt.response(cancel))
+			agent := newTestAgent(t, t.TempDir())
+			agent.model = *model
+			agent.cfg.Retry = RetryConfig{MaxRetries: 3, BaseDelayMs: 60000, MaxDelayMs: 60000}
+
+			start := time.Now()
+			outcome := agent.NewUserTask(taskCtx, "explain it")
+			if time.Since(start) > 10*time.Second {
+				t.Errorf("the canceled task returned after %v", time.Since(start))
+			}
+			if outcome.Status != OutcomeAborted || !strings.Contains(outcome.Reason, "interrupted") {
+				t.Errorf("outcome = %s, want aborted by the user", outcome)
+			}
+			if len(*requests) != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", len(*requests), tt.wantRequests)
+			}
+			if outcome.Answer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", outcome.Answer, tt.wantAnswer)
+			}
+			// the interrupted task is still remembered
+			if len(agent.History()) != 1 {
+				t.Errorf("history has %d tasks, want 1", len(agent.History()))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	ctx "llm_dev/context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type AgentConfig struct {
+	// ContextTokens is the token budget of the system prompt and the rendered context, 0 means no limit.
+	ContextTokens int `json:"context_tokens"`
+	// ContextMaxAge is the number of turns loaded file context is kept without being loaded again, 0 keeps it forever.
+	ContextMaxAge uint `json:"context_max_age"`
+	// MaxIterations is the max number of requests to the model in one task, 0 means no limit.
+	MaxIterations int `json:"max_iterations"`
+	// MaxToolCalls is the max number of tool calls in one task, 0 means no limit.
+	MaxToolCalls int `json:"max_tool_calls"`
+	// ToolConcurrency is the max number of read-only tool calls run at the same time, 1 runs them one by one.
+	ToolConcurrency int `json:"tool_concurrency"`
+	// Retry decides how the failed requests to the model are retried.
+	Retry RetryConfig `json:"retry"`
+	// PromptCache marks the stable request prefix and the history with cache_control for prompt caching.
+	PromptCache bool `json:"prompt_cache"`
+	// HistoryWindow is the number of latest task summaries sent with a new task, 0 keeps all.
+	HistoryWindow int `json:"history_window"`
+	// SessionDir is the directory the sessions are saved in.
+	SessionDir string `json:"session_dir"`
+	// Mode is the mode of the tasks when the agent starts, ask, plan or edit.
+	Mode ctx.Mode `json:"mode"`
+	// Profile is the name of the profile in Profiles used by the agent.
+	Profile string `json:"profile"`
+	// Profiles decide which context managers are used and their options, the profiles in the config
+	// file are added to the built-in ones, a profile with the same name replaces the built-in one.
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+type Profile struct {
+	// Context maps the registered context manager name to its config, a manager not listed is
+	// used if it is enabled by default.
+	Context map[string]ctx.MgrConfig `json:"context"`
+}
+
+func DefaultAgentConfig() AgentConfig {
+	disabled := false
+	return AgentConfig{
+		ContextTokens:   60000,
+		ContextMaxAge:   10,
+		MaxIterations:   50,
+		MaxToolCalls:    150,
+		ToolConcurrency: 4,
+		Retry:           RetryConfig{MaxRetries: 4, BaseDelayMs: 1000, MaxDelayMs: 30000},
+		PromptCache:     true,
+		HistoryWindow:   10,
+		SessionDir:      filepath.Join(configDir(), "sessions"),
+		Mode:            ctx.ModeAsk,
+		Profile:         "default",
+		Profiles: map[string]Profile{
+			"default": {},
+			// explain only reads the codebase, the edit tools are not provided
+			"explain": {Context: map[string]ctx.MgrConfig{"build": {Enabled: &disabled}}},
+		},
+	}
+}
+
+func configDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".llm_dev"
+	}
+	return filepath.Join(home, ".llm_dev")
+}
+
+// DefaultConfigPath is the config file loaded when no config file is given.
+func DefaultConfigPath() string {
+	return filepath.Join(configDir(), "config.json")
+}
+
+// LoadConfig reads the JSON config file at path over the default config, the fields not in the file keep
+// the default value. If path is empty the default config file is read when it exists.
+func LoadConfig(path string) (AgentConfig, error) {
+	cfg := DefaultAgentConfig()
+	if path == "" {
+		path = DefaultConfigPath()
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return cfg, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config file %s failed: %w", path, err)
+	}
+	if _, err := cfg.ActiveProfile(); err != nil {
+		return cfg, fmt.Errorf("config file %s: %w", path, err)
+	}
+	if _, err := ctx.ParseMode(string(cfg.Mode)); err != nil {
+		return cfg, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *AgentConfig) ActiveProfile() (Profile, error) {
+	profile, exist := cfg.Profiles[cfg.Profile]
+	if !exist {
+		return profile, fmt.Errorf("profile %s not found, available profiles: %v", cfg.Profile, slices.Sorted(maps.Keys(cfg.Profiles)))
+	}
+	return profile, nil
+}
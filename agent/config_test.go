@@ -0,0 +1,134 @@
+package agent
+
+import (
+	llmctx "llm_dev/context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("override defaults", func(t *testing.T) {
+		path := write("config.json", `{
+			"context_tokens": 1000,
+			"profile": "team",
+			"profiles": {"team": {"context": {"build": {"enabled": false}, "search": {"enabled": true, "options": {"semantic": false}}}}}
+		}`)
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ContextTokens != 1000 || cfg.ContextMaxAge != DefaultAgentConfig().ContextMaxAge || !cfg.PromptCache {
+			t.Errorf("unexpected config %+v", cfg)
+		}
+		for _, name := range []string{"default", "explain", "team"} {
+			if _, exist := cfg.Profiles[name]; !exist {
+				t.Errorf("profile %s not found", name)
+			}
+		}
+		profile, err := cfg.ActiveProfile()
+		if build := profile.Context["build"].Enabled; err != nil || build == nil || *build || string(profile.Context["search"].Options) != `{"semantic": false}` {
+			t.Errorf("unexpected profile %+v, %v", profile, err)
+		}
+	})
+	t.Run("unknown profile", func(t *testing.T) {
+		if _, err := LoadConfig(write("unknown.json", `{"profile": "missing"}`)); err == nil {
+			t.Errorf("LoadConfig() expects an error")
+		}
+	})
+	t.Run("invalid file", func(t *testing.T) {
+		if _, err := LoadConfig(write("invalid.json", `{`)); err == nil {
+			t.Errorf("LoadConfig() expects an error")
+		}
+		if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+			t.Errorf("LoadConfig() expects an error")
+		}
+	})
+}
+
+func TestBaseAgent_SetConfig(t *testing.T) {
+	agent := newTestAgent(t, t.TempDir())
+	cfg := DefaultAgentConfig()
+	cfg.Profile = "explain"
+	if err := agent.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, named := range agent.ctxMgrs {
+		if named.Name == "build" {
+			t.Errorf("explain profile should not use the build manager")
+		}
+	}
+	if agent.fileCtx == nil {
+		t.Errorf("file manager is not found")
+	}
+	cfg.Profile = "missing"
+	if err := agent.SetConfig(cfg); err == nil {
+		t.Errorf("SetConfig() expects an error")
+	}
+}
+
+func TestBaseAgent_SetMode(t *testing.T) {
+	agent := newTestAgent(t, t.TempDir())
+	toolNames := func() []string {
+		agent.applyMode()
+		ctx := NewAgentContext(nil, "task", agent.contextMgrs()...)
+		return slices.Sorted(maps.Keys(ctx.toolHandlerMap))
+	}
+	for _, tt := range []struct {
+		mode   llmctx.Mode
+		want   []string
+		absent []string
+	}{
+		{mode: llmctx.ModeAsk, absent: []string{"submit_plan", "apply_diff", "declare_action"}},
+		{mode: llmctx.ModePlan, want: []string{"submit_plan"}, absent: []string{"apply_diff", "declare_action"}},
+		{mode: llmctx.ModeEdit, want: []string{"apply_diff", "declare_action"}, absent: []string{"submit_plan"}},
+	} {
+		if err := agent.SetMode(tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		tools := toolNames()
+		for _, name := range tt.want {
+			if !slices.Contains(tools, name) {
+				t.Errorf("%s mode misses tool %s", tt.mode, name)
+			}
+		}
+		for _, name := range tt.absent {
+			if slices.Contains(tools, name) {
+				t.Errorf("%s mode should not have tool %s", tt.mode, name)
+			}
+		}
+		if strings.Contains(systemPrompt(tt.mode), "# Edit Tool") != (tt.mode == llmctx.ModeEdit) {
+			t.Errorf("%s mode system prompt has the wrong edit tool usage", tt.mode)
+		}
+	}
+
+	for _, mode := range []llmctx.Mode{"bogus", ""} {
+		if err := agent.SetMode(mode); err == nil {
+			t.Errorf("unknown mode %q is accepted", mode)
+		}
+	}
+	if agent.Mode() != llmctx.ModeEdit {
+		t.Errorf("mode = %s after an unknown mode, want edit", agent.Mode())
+	}
+
+	cfg := DefaultAgentConfig()
+	cfg.Profile = "explain"
+	if err := agent.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.SetMode(llmctx.ModeEdit); err == nil {
+		t.Errorf("edit mode expects an error without the build context manager")
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// RunConversation answers the last user message of a conversation kept by the caller, e.g. an API client
+// sending the whole conversation with every request. The earlier messages replace the task history, the
+// system messages are added to the system prompt as the client instructions, and the task is neither
+// memorized nor saved in the session. The tools are the ones of the context managers, the conversation
+// can not carry tool calls of its own.
+func (agent *BaseAgent) RunConversation(taskCtx context.Context, messages []openai.ChatCompletionMessage) (TaskOutcome, error) {
+	history, userprompt, instructions, err := splitConversation(messages)
+	if err != nil {
+		return TaskOutcome{}, err
+	}
+	sysPrompt := systemPrompt(agent.mode)
+	if instructions != "" {
+		sysPrompt += clientInstructionsHeader + instructions + "\n"
+	}
+	_, outcome := agent.startTask(taskCtx, history, userprompt, sysPrompt)
+	agent.emit(TaskFinishedEvent{Outcome: outcome})
+	return outcome, nil
+}
+
+// splitConversation checks the conversation and splits it into the history, the last user prompt and the
+// system instructions.
+func splitConversation(messages []openai.ChatCompletionMessage) (history []openai.ChatCompletionMessage, userprompt string, instructions string, err error) {
+	if len(messages) == 0 {
+		return nil, "", "", errors.New("the conversation is empty")
+	}
+	systems := []string{}
+	history = []openai.ChatCompletionMessage{}
+	for i, msg := range messages {
+		content, err := messageText(msg)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("message %d: %w", i, err)
+		}
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem, openai.ChatMessageRoleDeveloper:
+			systems = append(systems, content)
+		case openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+			if len(msg.ToolCalls) != 0 || msg.FunctionCall != nil {
+				return nil, "", "", fmt.Errorf("message %d: tool calls are run by the agent, the conversation can not carry them", i)
+			}
+			history = append(history, openai.ChatCompletionMessage{Role: msg.Role, Content: content})
+		default:
+			return nil, "", "", fmt.Errorf("message %d: role %q is not supported, the tools are run by the agent", i, msg.Role)
+		}
+	}
+	if len(history) == 0 || history[len(history)-1].Role != openai.ChatMessageRoleUser {
+		return nil, "", "", errors.New("the conversation must end with a user message")
+	}
+	userprompt = history[len(history)-1].Content
+	if strings.TrimSpace(userprompt) == "" {
+		return nil, "", "", errors.New("the last user message is empty")
+	}
+	return history[:len(history)-1], userprompt, strings.Join(systems, "\n\n"), nil
+}
+
+// messageText returns the text of the message, only text parts are supported in the multi content.
+func messageText(msg openai.ChatCompletionMessage) (string, error) {
+	if len(msg.MultiContent) == 0 {
+		return msg.Content, nil
+	}
+	parts := []string{}
+	for _, part := range msg.MultiContent {
+		if part.Type != openai.ChatMessagePartTypeText {
+			return "", fmt.Errorf("content part %q is not supported, only text is", part.Type)
+		}
+		parts = append(parts, part.Text)
+	}
+	return strings.Join(parts, "\n"), nil
+}
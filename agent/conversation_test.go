@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestSplitConversation(t *testing.T) {
+	history, prompt, instructions, err := splitConversation([]openai.ChatCompletionMessage{
+		{Role: "system", Content: "answer in French"},
+		{Role: "user", Content: "what is main.go"},
+		{Role: "assistant", Content: "the entry point"},
+		{Role: "user", MultiContent: []openai.ChatMessagePart{{Type: "text", Text: "and"}, {Type: "text", Text: "utils?"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Content != "what is main.go" || history[1].Role != "assistant" {
+		t.Errorf("history = %+v", history)
+	}
+	if prompt != "and\nutils?" || instructions != "answer in French" {
+		t.Errorf("prompt = %q, instructions = %q", prompt, instructions)
+	}
+
+	invalid := map[string][]openai.ChatCompletionMessage{
+		"empty":            {},
+		"no user message":  {{Role: "system", Content: "be short"}},
+		"ends with answer": {{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+		"tool message":     {{Role: "user", Content: "hi"}, {Role: "tool", Content: "{}", ToolCallID: "call_1"}, {Role: "user", Content: "go on"}},
+		"tool calls": {
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function"}}},
+			{Role: "user", Content: "go on"},
+		},
+		"image":        {{Role: "user", MultiContent: []openai.ChatMessagePart{{Type: "image_url"}}}},
+		"empty prompt": {{Role: "user", Content: "  "}},
+	}
+	for name, messages := range invalid {
+		if _, _, _, err := splitConversation(messages); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestBaseAgent_RunConversation(t *testing.T) {
+	model, requests := newFakeModel(t, textResponse("the entry point of utils", openai.FinishReasonStop))
+	agent := newTestAgent(t, t.TempDir())
+	agent.model = *model
+	agent.cfg.PromptCache = false
+
+	outcome, err := agent.RunConversation(t.Context(), []openai.ChatCompletionMessage{
+		{Role: "system", Content: "answer in French"},
+		{Role: "user", Content: "what is main.go"},
+		{Role: "assistant", Content: "the entry point"},
+		{Role: "user", Content: "and utils?"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Status != OutcomeCompleted || outcome.Answer != "the entry point of utils" {
+		t.Errorf("outcome = %+v", outcome)
+	}
+	req := (*requests)[0]
+	if sys := req.Messages[0].Content; !strings.Contains(sys, clientInstructionsHeader+"answer in French") {
+		t.Errorf("system message misses the client instructions:\n%s", sys)
+	}
+	roles := []string{}
+	for _, msg := range req.Messages[1:4] {
+		roles = append(roles, msg.Role+":"+msg.Content)
+	}
+	if want := "user:what is main.go assistant:the entry point user:and utils?"; strings.Join(roles, " ") != want {
+		t.Errorf("messages = %v, want %s", roles, want)
+	}
+	if len(agent.History()) != 0 {
+		t.Errorf("the conversation is memorized: %+v", agent.History())
+	}
+
+	if _, err := agent.RunConversation(t.Context(), []openai.ChatCompletionMessage{{Role: "tool", Content: "{}"}}); err == nil {
+		t.Error("a tool message is accepted")
+	}
+	if len(*requests) != 1 {
+		t.Errorf("%d requests, an invalid conversation must not be sent", len(*requests))
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"time"
+
+	llmctx "llm_dev/context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type EventType string
+
+const (
+	EventTurnStarted       EventType = "turn_started"
+	EventContextRendered   EventType = "context_rendered"
+	EventTextDelta         EventType = "text_delta"
+	EventResponseFinished  EventType = "response_finished"
+	EventResponseDiscarded EventType = "response_discarded"
+	EventToolCallStarted   EventType = "tool_call_started"
+	EventToolCallFinished  EventType = "tool_call_finished"
+	EventUsage             EventType = "usage"
+	EventError             EventType = "error"
+	EventTaskFinished      EventType = "task_finished"
+)
+
+// Event is what the agent reports while it runs a task, the front-ends switch on the concrete type.
+type Event interface {
+	EventType() EventType
+}
+
+// EventHandler receives the events of the tasks in order. It is called on the goroutine running the
+// task, so it must return quickly and hand the events over if the consumer is slow.
+type EventHandler func(Event)
+
+// TurnStartedEvent is sent before every request to the model.
+type TurnStartedEvent struct {
+	Turn int `json:"turn"`
+}
+
+// ContextRenderedEvent carries the volatile context sent with the request.
+type ContextRenderedEvent struct {
+	Turn    int                 `json:"turn"`
+	Context string              `json:"context"`
+	Usage   llmctx.ContextUsage `json:"usage"`
+}
+
+// TextDeltaEvent is a piece of the streamed response content.
+type TextDeltaEvent struct {
+	Text string `json:"text"`
+}
+
+// ResponseFinishedEvent is sent when the response is complete, before its tool calls run.
+type ResponseFinishedEvent struct {
+	Message      openai.ChatCompletionMessage `json:"message"`
+	FinishReason openai.FinishReason          `json:"finish_reason"`
+}
+
+// ResponseDiscardedEvent is sent when a streamed response fails partway and is requested again, the
+// TextDeltaEvents of Text are withdrawn and the retried response streams from its start.
+type ResponseDiscardedEvent struct {
+	Text string `json:"text"`
+}
+
+type ToolCallStartedEvent struct {
+	Call     openai.ToolCall `json:"call"`
+	ReadOnly bool            `json:"read_only"`
+}
+
+type ToolCallFinishedEvent struct {
+	Call   openai.ToolCall `json:"call"`
+	Result string          `json:"result"`
+	// Err is set if the tool call failed, Result then holds the error sent to the model.
+	Err *ToolError `json:"error,omitempty"`
+}
+
+// UsageEvent is the token usage of the task so far, sent after every response reporting usage.
+type UsageEvent struct {
+	Usage TokenUsage `json:"usage"`
+}
+
+// ErrorEvent is a failed request, Retry is the number of the retry sent after Delay, 0 if the
+// request is not retried.
+type ErrorEvent struct {
+	Err     error         `json:"-"`
+	Message string        `json:"message"`
+	Retry   int           `json:"retry,omitempty"`
+	Delay   time.Duration `json:"delay,omitempty"`
+}
+
+type TaskFinishedEvent struct {
+	Outcome TaskOutcome `json:"outcome"`
+}
+
+func (TurnStartedEvent) EventType() EventType       { return EventTurnStarted }
+func (ContextRenderedEvent) EventType() EventType   { return EventContextRendered }
+func (TextDeltaEvent) EventType() EventType         { return EventTextDelta }
+func (ResponseFinishedEvent) EventType() EventType  { return EventResponseFinished }
+func (ResponseDiscardedEvent) EventType() EventType { return EventResponseDiscarded }
+func (ToolCallStartedEvent) EventType() EventType   { return EventToolCallStarted }
+func (ToolCallFinishedEvent) EventType() EventType  { return EventToolCallFinished }
+func (UsageEvent) EventType() EventType             { return EventUsage }
+func (ErrorEvent) EventType() EventType             { return EventError }
+func (TaskFinishedEvent) EventType() EventType      { return EventTaskFinished }
+
+// SetEventHandler sets the handler receiving the events of the following tasks, nil drops the events.
+func (agent *BaseAgent) SetEventHandler(handler EventHandler) {
+	agent.onEvent = handler
+}
+
+func (agent *BaseAgent) emit(event Event) {
+	if agent.onEvent != nil {
+		agent.onEvent(event)
+	}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBaseAgent_events(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "README.md"), []byte("# demo\n"), 0644)
+	model, _ := newFakeModel(t,
+		sseResponse{status: http.StatusServiceUnavailable},
+		toolCallResponse("call_1", "read_file", `{"file":"README.md","start_line":1}`),
+		textResponse("a demo", openai.FinishReasonStop),
+	)
+	agent := newTestAgent(t, root)
+	agent.model = *model
+	agent.cfg.Retry = RetryConfig{MaxRetries: 1, BaseDelayMs: 1, MaxDelayMs: 1}
+	events := []Event{}
+	agent.SetEventHandler(func(event Event) { events = append(events, event) })
+
+	outcome := agent.NewUserTask(t.Context(), "what is it")
+	types := []EventType{}
+	for _, event := range events {
+		types = append(types, event.EventType())
+	}
+	want := []EventType{
+		EventTurnStarted, EventContextRendered, EventError, EventContextRendered, EventResponseFinished,
+		EventToolCallStarted, EventToolCallFinished,
+		EventTurnStarted, EventContextRendered, EventTextDelta, EventUsage, EventResponseFinished,
+		EventTaskFinished,
+	}
+	if !slices.Equal(types, want) {
+		t.Fatalf("events = %v\nwant %v", types, want)
+	}
+	if e := events[2].(ErrorEvent); e.Retry != 1 || e.Message == "" {
+		t.Errorf("error event = %+v, want the first retry", e)
+	}
+	if e := events[6].(ToolCallFinishedEvent); e.Call.ID != "call_1" || e.Err != nil || e.Result == "" {
+		t.Errorf("tool call finished = %+v", e)
+	}
+	if e := events[9].(TextDeltaEvent); e.Text != "a demo" {
+		t.Errorf("text delta = %q", e.Text)
+	}
+	if e := events[len(events)-1].(TaskFinishedEvent); e.Outcome.Status != OutcomeCompleted || e.Outcome.Answer != outcome.Answer {
+		t.Errorf("task finished = %+v, want %+v", e.Outcome, outcome)
+	}
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	maxTranscriptToolResult = 2000
+	maxTranscriptSize       = 60000
+	maxFallbackSummary      = 1500
+	// summaryTimeout bounds the summary request, the task is remembered with its final answer after it.
+	summaryTimeout = 2 * time.Minute
+)
+
+// editTools are the tools modifying the codebase files, the files in their arguments are recorded as edited.
+var editTools = []string{"apply_diff", "insert_action", "replace_action"}
+
+// TaskMemory is the condensed record of a finished task, it replaces the full conversation of the task
+// in the history sent with the following tasks.
+type TaskMemory struct {
+	Prompt  string `json:"prompt"`
+	Summary string `json:"summary"`
+	// Files are the files the tools read or searched during the task, Edited are the files modified.
+	Files  []string `json:"files,omitempty"`
+	Edited []string `json:"edited,omitempty"`
+}
+
+func (mem *TaskMemory) messages() []openai.ChatCompletionMessage {
+	var content strings.Builder
+	content.WriteString("[SUMMARY OF THE FINISHED TASK]\n")
+	content.WriteString(mem.Summary)
+	content.WriteByte('\n')
+	if len(mem.Edited) != 0 {
+		content.WriteString(fmt.Sprintf("Edited files: %s\n", strings.Join(mem.Edited, ", ")))
+	}
+	if len(mem.Files) != 0 {
+		content.WriteString(fmt.Sprintf("Files examined: %s\n", strings.Join(mem.Files, ", ")))
+	}
+	return []openai.ChatCompletionMessage{
+		{Role: "user", Content: mem.Prompt},
+		{Role: "assistant", Content: content.String()},
+	}
+}
+
+func (mem *TaskMemory) write(w io.Writer, index int) {
+	fmt.Fprintf(w, "## Task %d: %s\n", index, mem.Prompt)
+	fmt.Fprintf(w, "%s\n", mem.Summary)
+	if len(mem.Edited) != 0 {
+		fmt.Fprintf(w, "Edited files: %s\n", strings.Join(mem.Edited, ", "))
+	}
+	if len(mem.Files) != 0 {
+		fmt.Fprintf(w, "Files examined: %s\n", strings.Join(mem.Files, ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// touchedFiles collects the file and path arguments of the tool calls in history, sorted and deduplicated.
+func touchedFiles(history []openai.ChatCompletionMessage) (files []string, edited []string) {
+	for _, msg := range history {
+		for _, toolCall := range msg.ToolCalls {
+			args := map[string]any{}
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+				continue
+			}
+			paths := []string{}
+			for _, key := range []string{"file", "path"} {
+				switch value := args[key].(type) {
+				case string:
+					paths = append(paths, value)
+				case []any:
+					for _, elem := range value {
+						if path, ok := elem.(string); ok {
+							paths = append(paths, path)
+						}
+					}
+				}
+			}
+			for _, path := range paths {
+				if path == "" || path == "." {
+					continue
+				}
+				if slices.Contains(editTools, toolCall.Function.Name) {
+					edited = append(edited, path)
+				} else {
+					files = append(files, path)
+				}
+			}
+		}
+	}
+	slices.Sort(files)
+	slices.Sort(edited)
+	return slices.Compact(files), slices.Compact(edited)
+}
+
+// transcript renders the conversation of a task as plain text for the summarizer, long tool results
+// are truncated and the oldest messages are dropped if the transcript is still too long.
+func transcript(prompt string, history []openai.ChatCompletionMessage) string {
+	parts := []string{fmt.Sprintf("USER:\n%s\n", prompt)}
+	for _, msg := range history {
+		var part strings.Builder
+		switch msg.Role {
+		case "assistant":
+			if msg.Content != "" {
+				part.WriteString(fmt.Sprintf("ASSISTANT:\n%s\n", msg.Content))
+			}
+			for _, toolCall := range msg.ToolCalls {
+				part.WriteString(fmt.Sprintf("TOOL CALL: %s %s\n", toolCall.Function.Name, toolCall.Function.Arguments))
+			}
+		case "tool":
+			content := msg.Content
+			if len(content) > maxTranscriptToolResult {
+				content = content[:maxTranscriptToolResult] + "\n... truncated"
+			}
+			part.WriteString(fmt.Sprintf("TOOL RESULT:\n%s\n", content))
+		default:
+			part.WriteString(fmt.Sprintf("%s:\n%s\n", strings.ToUpper(msg.Role), msg.Content))
+		}
+		parts = append(parts, part.String())
+	}
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	// keep the user prompt, drop the oldest messages after it
+	for size > maxTranscriptSize && len(parts) > 2 {
+		size -= len(parts[1])
+		parts = slices.Delete(parts, 1, 2)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// finalAnswer returns the content of the last assistant message of the task, joined with the responses
+// it continues, the responses cut off at the max output tokens.
+func finalAnswer(history []openai.ChatCompletionMessage) string {
+	i := len(history) - 1
+	for i >= 0 && (history[i].Role != "assistant" || history[i].Content == "") {
+		i--
+	}
+	if i < 0 {
+		return ""
+	}
+	parts := []string{history[i].Content}
+	for ; i >= 2 && continued(history[i-1]) && history[i-2].Role == "assistant" && history[i-2].Content != ""; i -= 2 {
+		parts = append(parts, history[i-2].Content)
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, "")
+}
+
+// continued tells if the message asks the model to continue its truncated response.
+func continued(msg openai.ChatCompletionMessage) bool {
+	return msg.Role == "user" && msg.Content == truncatedPrompt
+}
+
+type Summarizer interface {
+	// Summarize condenses the conversation of a finished task into a compact summary.
+	Summarize(ctx context.Context, prompt string, history []openai.ChatCompletionMessage) (string, error)
+}
+
+// ModelSummarizer asks the chat model to summarize the task.
+type ModelSummarizer struct {
+	client *openai.Client
+	model  string
+}
+
+func NewModelSummarizer(client *openai.Client, model string) *ModelSummarizer {
+	return &ModelSummarizer{
+		client: client,
+		model:  model,
+	}
+}
+
+func (s *ModelSummarizer) Summarize(ctx context.Context, prompt string, history []openai.ChatCompletionMessage) (string, error) {
+	req := openai.ChatCompletionRequest{
+		Model: s.model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: transcript(prompt, history)},
+		},
+	}
+	resp, err := s.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty summary response")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// memorize condenses the finished task into a TaskMemory and appends it to the history,
+// only the latest HistoryWindow tasks are kept. An aborted task is not summarized, it keeps its final
+// answer or its prompt, so an interrupted task returns at once.
+func (agent *BaseAgent) memorize(taskCtx context.Context, ctx *AgentContext, outcome TaskOutcome) {
+	mem := TaskMemory{Prompt: ctx.userPrompt}
+	mem.Files, mem.Edited = touchedFiles(ctx.history)
+	if agent.summarizer != nil && outcome.Status != OutcomeAborted {
+		summaryCtx, cancel := context.WithTimeout(taskCtx, summaryTimeout)
+		summary, err := agent.summarizer.Summarize(summaryCtx, ctx.userPrompt, ctx.history)
+		cancel()
+		if err != nil {
+			log.Error().Err(err).Msg("summarize task failed, keep the final answer instead")
+		}
+		mem.Summary = summary
+	}
+	if mem.Summary == "" {
+		mem.Summary = finalAnswer(ctx.history)
+		if len(mem.Summary) > maxFallbackSummary {
+			mem.Summary = mem.Summary[:maxFallbackSummary] + "\n... truncated"
+		}
+	}
+	if mem.Summary == "" && outcome.Status == OutcomeAborted {
+		mem.Summary = fmt.Sprintf("the task is aborted before an answer: %s", outcome.Reason)
+	}
+	agent.history = append(agent.history, mem)
+	if window := agent.cfg.HistoryWindow; window > 0 && len(agent.history) > window {
+		agent.history = slices.Clone(agent.history[len(agent.history)-window:])
+	}
+}
+
+// historyMessages renders the task memories as the messages sent before the user prompt.
+func (agent *BaseAgent) historyMessages() []openai.ChatCompletionMessage {
+	res := []openai.ChatCompletionMessage{}
+	for i := range agent.history {
+		res = append(res, agent.history[i].messages()...)
+	}
+	return res
+}
+
+func (agent *BaseAgent) History() []TaskMemory {
+	return agent.history
+}
+
+func (agent *BaseAgent) ClearHistory() {
+	agent.history = nil
+	agent.saveSession()
+}
+
+func (agent *BaseAgent) WriteHistory(w io.Writer) {
+	if len(agent.history) == 0 {
+		fmt.Fprintln(w, "no task in history")
+		return
+	}
+	for i := range agent.history {
+		agent.history[i].write(w, i+1)
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func toolCallMsg(name string, args string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role: "assistant",
+		ToolCalls: []openai.ToolCall{
+			{Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: args}},
+		},
+	}
+}
+
+type stubSummarizer struct {
+	summary string
+	err     error
+	calls   int
+}
+
+func (s *stubSummarizer) Summarize(ctx context.Context, prompt string, history []openai.ChatCompletionMessage) (string, error) {
+	s.calls++
+	return s.summary, s.err
+}
+
+func TestBaseAgent_memorizeAborted(t *testing.T) {
+	summarizer := &stubSummarizer{summary: "- summary"}
+	agent := BaseAgent{summarizer: summarizer}
+	taskCtx, cancel := context.WithCancel(t.Context())
+	cancel()
+	aborted := TaskOutcome{Status: OutcomeAborted, Reason: "interrupted by the user"}
+
+	ctx := NewAgentContext(nil, "explain it")
+	ctx.addMessage(openai.ChatCompletionMessage{Role: "assistant", Content: "half an answer"})
+	agent.memorize(taskCtx, ctx, aborted)
+	agent.memorize(taskCtx, NewAgentContext(nil, "fix it"), aborted)
+
+	if summarizer.calls != 0 {
+		t.Errorf("the aborted tasks are summarized %d times", summarizer.calls)
+	}
+	history := agent.History()
+	if len(history) != 2 || history[0].Summary != "half an answer" || history[1].Prompt != "fix it" ||
+		!strings.Contains(history[1].Summary, "interrupted by the user") {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestTouchedFiles(t *testing.T) {
+	history := []openai.ChatCompletionMessage{
+		toolCallMsg("load_file_context", `{"file":["b.go","a.go"]}`),
+		toolCallMsg("get_directory_overview", `{"path":"."}`),
+		toolCallMsg("search_code", `{"pattern":"x","path":"context","glob":"","context_lines":0}`),
+		toolCallMsg("apply_diff", `{"file":"a.go","diff":"..."}`),
+		toolCallMsg("load_lines", `{"file":"a.go","start_line":1,"end_line":2}`),
+		toolCallMsg("read_file", `not json`),
+	}
+	files, edited := touchedFiles(history)
+	if want := []string{"a.go", "b.go", "context"}; !slices.Equal(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	if want := []string{"a.go"}; !slices.Equal(edited, want) {
+		t.Errorf("edited = %v, want %v", edited, want)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	t.Run("truncate tool result", func(t *testing.T) {
+		history := []openai.ChatCompletionMessage{
+			toolCallMsg("read_file", `{"file":"a.go"}`),
+			{Role: "tool", Content: strings.Repeat("x", maxTranscriptToolResult+100)},
+			{Role: "assistant", Content: "done"},
+		}
+		got := transcript("explain a.go", history)
+		if !strings.HasPrefix(got, "USER:\nexplain a.go\n") || !strings.Contains(got, "TOOL CALL: read_file") ||
+			!strings.Contains(got, "... truncated") || !strings.HasSuffix(got, "ASSISTANT:\ndone\n") {
+			t.Errorf("unexpected transcript:\n%s", got)
+		}
+	})
+	t.Run("drop oldest messages", func(t *testing.T) {
+		history := []openai.ChatCompletionMessage{}
+		for i := 0; i < 100; i++ {
+			history = append(history, openai.ChatCompletionMessage{Role: "assistant", Content: fmt.Sprintf("step %d %s", i, strings.Repeat("y", 1000))})
+		}
+		got := transcript("prompt", history)
+		if len(got) > maxTranscriptSize || !strings.HasPrefix(got, "USER:\nprompt\n") || strings.Contains(got, "step 0 ") || !strings.Contains(got, "step 99 ") {
+			t.Errorf("transcript is not trimmed from the oldest message, size %d", len(got))
+		}
+	})
+}
+
+func TestBaseAgent_memorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		summarizer Summarizer
+		window     int
+		tasks      int
+		want       []string
+	}{
+		{name: "model summary", summarizer: &stubSummarizer{summary: "- summary"}, window: 2, tasks: 1, want: []string{"- summary"}},
+		{name: "fallback to final answer", summarizer: &stubSummarizer{err: errors.New("fail")}, window: 2, tasks: 1, want: []string{"answer 0"}},
+		{name: "no summarizer", summarizer: nil, window: 2, tasks: 1, want: []string{"answer 0"}},
+		{name: "rolling window", summarizer: nil, window: 2, tasks: 3, want: []string{"answer 1", "answer 2"}},
+		{name: "no window", summarizer: nil, window: 0, tasks: 3, want: []string{"answer 0", "answer 1", "answer 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := BaseAgent{summarizer: tt.summarizer, cfg: AgentConfig{HistoryWindow: tt.window}}
+			for i := 0; i < tt.tasks; i++ {
+				ctx := NewAgentContext(agent.historyMessages(), fmt.Sprintf("task %d", i))
+				ctx.addMessage(toolCallMsg("load_file_context", `{"file":["a.go"]}`))
+				ctx.addMessage(openai.ChatCompletionMessage{Role: "assistant", Content: fmt.Sprintf("answer %d", i)})
+				agent.memorize(t.Context(), ctx, TaskOutcome{Status: OutcomeCompleted})
+			}
+			got := []string{}
+			for _, mem := range agent.History() {
+				got = append(got, mem.Summary)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("summaries = %v, want %v", got, tt.want)
+			}
+			msgs := agent.historyMessages()
+			if len(msgs) != 2*len(tt.want) || msgs[1].Role != "assistant" || !strings.Contains(msgs[1].Content, "Files examined: a.go") {
+				t.Errorf("unexpected history messages: %v", msgs)
+			}
+			var buf bytes.Buffer
+			agent.ClearHistory()
+			agent.WriteHistory(&buf)
+			if buf.String() != "no task in history\n" {
+				t.Errorf("history is not cleared: %s", buf.String())
+			}
+		})
+	}
+}
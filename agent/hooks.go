@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"io"
+	llmctx "llm_dev/context"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+func (ctx *AgentContext) taskStart() {
+	for _, mgr := range ctx.ctxMgr {
+		if hook, ok := mgr.(llmctx.TaskStartHook); ok {
+			hook.OnTaskStart(ctx.userPrompt)
+		}
+	}
+}
+
+// fileChanged collects the files changed by the tool calls and passes them to the FileChangedHook managers.
+func (ctx *AgentContext) fileChanged() {
+	changed := []string{}
+	for _, mgr := range ctx.ctxMgr {
+		if reporter, ok := mgr.(llmctx.FileChangeReporter); ok {
+			changed = append(changed, reporter.ChangedFiles()...)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	slices.Sort(changed)
+	changed = slices.Compact(changed)
+	for _, mgr := range ctx.ctxMgr {
+		if hook, ok := mgr.(llmctx.FileChangedHook); ok {
+			hook.OnFileChanged(changed)
+		}
+	}
+}
+
+func (ctx *AgentContext) turnEnd() {
+	for _, mgr := range ctx.ctxMgr {
+		if hook, ok := mgr.(llmctx.TurnEndHook); ok {
+			hook.OnTurnEnd()
+		}
+	}
+}
+
+func (ctx *AgentContext) taskEnd() {
+	for _, mgr := range ctx.ctxMgr {
+		if hook, ok := mgr.(llmctx.TaskEndHook); ok {
+			hook.OnTaskEnd()
+		}
+	}
+}
+
+// Close saves the session and closes the context managers holding resources.
+func (agent *BaseAgent) Close() error {
+	agent.saveSession()
+	var res error
+	for _, named := range agent.ctxMgrs {
+		closer, ok := named.Mgr.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.Error().Err(err).Str("context", named.Name).Msg("close context manager fail")
+			res = err
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	llmctx "llm_dev/context"
+	"llm_dev/model"
+	"slices"
+	"testing"
+)
+
+type hookCtxMgr struct {
+	events  []string
+	changed []string
+}
+
+func (mgr *hookCtxMgr) WriteContext(buf *bytes.Buffer)           {}
+func (mgr *hookCtxMgr) GetToolDef() []model.ToolDef              { return nil }
+func (mgr *hookCtxMgr) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (mgr *hookCtxMgr) RestoreState(state json.RawMessage) error { return nil }
+func (mgr *hookCtxMgr) OnTaskStart(prompt string)                { mgr.events = append(mgr.events, "start "+prompt) }
+func (mgr *hookCtxMgr) OnTurnEnd()                               { mgr.events = append(mgr.events, "turn") }
+func (mgr *hookCtxMgr) OnTaskEnd()                               { mgr.events = append(mgr.events, "end") }
+func (mgr *hookCtxMgr) Close() error                             { mgr.events = append(mgr.events, "close"); return nil }
+func (mgr *hookCtxMgr) OnFileChanged(paths []string) {
+	for _, path := range paths {
+		mgr.events = append(mgr.events, "changed "+path)
+	}
+}
+func (mgr *hookCtxMgr) ChangedFiles() []string {
+	res := mgr.changed
+	mgr.changed = nil
+	return res
+}
+
+func TestAgentContext_hooks(t *testing.T) {
+	first := &hookCtxMgr{changed: []string{"b.go", "a.go"}}
+	second := &hookCtxMgr{changed: []string{"a.go"}}
+	ctx := NewAgentContext(nil, "task", first, second)
+	ctx.taskStart()
+	ctx.fileChanged()
+	ctx.turnEnd()
+	ctx.fileChanged()
+	ctx.turnEnd()
+	ctx.taskEnd()
+	agent := BaseAgent{ctxMgrs: []llmctx.NamedMgr{{Name: "first", Mgr: first}}}
+	if err := agent.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start task", "changed a.go", "changed b.go", "turn", "turn", "end"}
+	if !slices.Equal(second.events, want) {
+		t.Errorf("events = %v, want %v", second.events, want)
+	}
+	if !slices.Equal(first.events, append(want, "close")) {
+		t.Errorf("events = %v, want %v", first.events, append(want, "close"))
+	}
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"fmt"
+	llmctx "llm_dev/context"
+)
+
+// SetMode selects the mode of the following tasks. Plan and edit modes need the build context manager,
+// they can not be used with a profile disabling it.
+func (agent *BaseAgent) SetMode(mode llmctx.Mode) error {
+	if _, err := llmctx.ParseMode(string(mode)); err != nil {
+		return err
+	}
+	if mode != llmctx.ModeAsk {
+		found := false
+		for _, named := range agent.ctxMgrs {
+			_, ok := named.Mgr.(*llmctx.BuildContextMgr)
+			found = found || ok
+		}
+		if !found {
+			return fmt.Errorf("%s mode needs the build context manager, it is disabled by profile %s", mode, agent.cfg.Profile)
+		}
+	}
+	agent.mode = mode
+	if agent.session != nil {
+		agent.session.Mode = mode
+	}
+	return nil
+}
+
+func (agent *BaseAgent) Mode() llmctx.Mode {
+	return agent.mode
+}
+
+// applyMode passes the mode to the mode aware managers, it is done before the tools of a task are registered.
+func (agent *BaseAgent) applyMode() {
+	for _, named := range agent.ctxMgrs {
+		if aware, ok := named.Mgr.(llmctx.ModeAware); ok {
+			aware.SetMode(agent.mode)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+)
+
+type OutcomeStatus string
+
+const (
+	// OutcomeCompleted means the model finished the task with a final answer.
+	OutcomeCompleted OutcomeStatus = "completed"
+	// OutcomeAborted means the task is stopped before it is finished, e.g. the response is filtered.
+	OutcomeAborted OutcomeStatus = "aborted"
+	// OutcomeBudgetExhausted means the task used up the iteration or tool call budget.
+	OutcomeBudgetExhausted OutcomeStatus = "budget_exhausted"
+	// OutcomeError means the request to the model failed.
+	OutcomeError OutcomeStatus = "error"
+)
+
+// TaskOutcome is the result of a user task returned by NewUserTask.
+type TaskOutcome struct {
+	Status OutcomeStatus `json:"status"`
+	// Reason tells why the task stopped, e.g. the finish reason of the last response or the exhausted budget.
+	Reason string `json:"reason,omitempty"`
+	Err    error  `json:"-"`
+	// Error is the message of Err, error values have no JSON form.
+	Error string `json:"error,omitempty"`
+	// Answer is the content of the last assistant message, joined with the truncated responses it continues.
+	Answer     string     `json:"answer"`
+	Iterations int        `json:"iterations"`
+	ToolCalls  int        `json:"tool_calls"`
+	Usage      TokenUsage `json:"usage"`
+}
+
+func (outcome TaskOutcome) String() string {
+	res := fmt.Sprintf("%s after %d iterations and %d tool calls", outcome.Status, outcome.Iterations, outcome.ToolCalls)
+	if outcome.Reason != "" {
+		res += fmt.Sprintf(", %s", outcome.Reason)
+	}
+	if outcome.Err != nil {
+		res += fmt.Sprintf(", error: %v", outcome.Err)
+	}
+	return res
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// sseResponse is one scripted response of the fake model, status other than 200 sends an error.
+// If hang is set it is called after the chunks are sent and the stream is kept open until the
+// request is canceled.
+type sseResponse struct {
+	status int
+	header http.Header
+	chunks []openai.ChatCompletionStreamResponse
+	hang   func()
+}
+
+func textResponse(content string, reason openai.FinishReason) sseResponse {
+	return sseResponse{chunks: []openai.ChatCompletionStreamResponse{
+		{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Role: "assistant", Content: content}}}},
+		{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: reason}}},
+		{Usage: &openai.Usage{PromptTokens: 10, CompletionTokens: 5}},
+	}}
+}
+
+func toolCallResponse(id string, name string, args string) sseResponse {
+	index := 0
+	return sseResponse{chunks: []openai.ChatCompletionStreamResponse{
+		{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{
+			Role:      "assistant",
+			ToolCalls: []openai.ToolCall{{Index: &index, ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: args}}},
+		}}}},
+		{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonToolCalls}}},
+	}}
+}
+
+// newFakeModel serves the responses in order, the last one is repeated when they run out.
+func newFakeModel(t *testing.T, responses ...sseResponse) (*Model, *[]openai.ChatCompletionRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := []openai.ChatCompletionRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		req := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := responses[min(len(requests), len(responses)-1)]
+		requests = append(requests, req)
+		for key, values := range resp.header {
+			w.Header()[key] = values
+		}
+		if resp.status != 0 && resp.status != http.StatusOK {
+			w.WriteHeader(resp.status)
+			fmt.Fprintf(w, `{"error":{"message":"status %d","type":"server_error"}}`, resp.status)
+			if resp.hang != nil {
+				resp.hang()
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range resp.chunks {
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if resp.hang != nil {
+			w.(http.Flusher).Flush()
+			mu.Unlock()
+			resp.hang()
+			<-r.Context().Done()
+			mu.Lock()
+			return
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return NewModel(server.URL, "sk-test"), &requests
+}
+
+func TestBaseAgent_NewUserTaskOutcome(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "README.md"), []byte("# demo\n"), 0644)
+	readme := toolCallResponse("call_1", "read_file", `{"file":"README.md","start_line":1}`)
+
+	tests := []struct {
+		name          string
+		responses     []sseResponse
+		maxIterations int
+		maxToolCalls  int
+		wantStatus    OutcomeStatus
+		wantRequests  int
+		wantToolCalls int
+		wantAnswer    string
+	}{
+		{
+			name:         "stop",
+			responses:    []sseResponse{textResponse("done", openai.FinishReasonStop)},
+			wantStatus:   OutcomeCompleted,
+			wantRequests: 1,
+			wantAnswer:   "done",
+		},
+		{
+			name:          "tool calls then stop",
+			responses:     []sseResponse{readme, textResponse("it is a demo", openai.FinishReasonStop)},
+			wantStatus:    OutcomeCompleted,
+			wantRequests:  2,
+			wantToolCalls: 1,
+			wantAnswer:    "it is a demo",
+		},
+		{
+			name:         "no finish reason",
+			responses:    []sseResponse{textResponse("done", "")},
+			wantStatus:   OutcomeCompleted,
+			wantRequests: 1,
+			wantAnswer:   "done",
+		},
+		{
+			name:          "max iterations",
+			responses:     []sseResponse{readme},
+			maxIterations: 3,
+			wantStatus:    OutcomeBudgetExhausted,
+			wantRequests:  3,
+			wantToolCalls: 3,
+		},
+		{
+			name:          "max tool calls",
+			responses:     []sseResponse{readme},
+			maxToolCalls:  2,
+			wantStatus:    OutcomeBudgetExhausted,
+			wantRequests:  2,
+			wantToolCalls: 2,
+		},
+		{
+			name:         "content filter",
+			responses:    []sseResponse{textResponse("", openai.FinishReasonContentFilter)},
+			wantStatus:   OutcomeAborted,
+			wantRequests: 1,
+		},
+		{
+			name:         "length is continued",
+			responses:    []sseResponse{textResponse("part one", openai.FinishReasonLength), textResponse(" part two", openai.FinishReasonStop)},
+			wantStatus:   OutcomeCompleted,
+			wantRequests: 2,
+			wantAnswer:   "part one part two",
+		},
+		{
+			name:         "length too many times",
+			responses:    []sseResponse{textResponse("part", openai.FinishReasonLength)},
+			wantStatus:   OutcomeAborted,
+			wantRequests: maxTruncatedResponses + 1,
+			wantAnswer:   strings.Repeat("part", maxTruncatedResponses+1),
+		},
+		{
+			name:         "request error",
+			responses:    []sseResponse{{status: http.StatusBadRequest}},
+			wantStatus:   OutcomeError,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			model, requests := newFakeModel(t, tt.responses...)
+			agent := newTestAgent(t, root)
+			agent.model = *model
+			agent.cfg.PromptCache = false
+			agent.cfg.MaxIterations = tt.maxIterations
+			agent.cfg.MaxToolCalls = tt.maxToolCalls
+
+			outcome := agent.NewUserTask(t.Context(), "what is it")
+			if outcome.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s: %s", outcome.Status, tt.wantStatus, outcome)
+			}
+			if len(*requests) != tt.wantRequests || outcome.Iterations != tt.wantRequests {
+				t.Errorf("requests = %d, iterations = %d, want %d", len(*requests), outcome.Iterations, tt.wantRequests)
+			}
+			if outcome.ToolCalls != tt.wantToolCalls {
+				t.Errorf("tool calls = %d, want %d", outcome.ToolCalls, tt.wantToolCalls)
+			}
+			if outcome.Answer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", outcome.Answer, tt.wantAnswer)
+			}
+			if (outcome.Err != nil) != (tt.wantStatus == OutcomeError) {
+				t.Errorf("err = %v", outcome.Err)
+			}
+			if outcome.Err != nil && outcome.Error != outcome.Err.Error() {
+				t.Errorf("error = %q, want %q", outcome.Error, outcome.Err.Error())
+			}
+		})
+	}
+}
+
+func TestBaseAgent_toolCallBudgetCapsParallelCalls(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "README.md"), []byte("# demo\n"), 0644)
+	parallel := toolCallResponse("call_0", "read_file", `{"file":"README.md","start_line":1}`)
+	delta := &parallel.chunks[0].Choices[0].Delta
+	for i := 1; i < 3; i++ {
+		call := delta.ToolCalls[0]
+		call.Index, call.ID = &i, fmt.Sprintf("call_%d", i)
+		delta.ToolCalls = append(delta.ToolCalls, call)
+	}
+	t.Chdir(t.TempDir())
+	model, requests := newFakeModel(t, parallel)
+	agent := newTestAgent(t, root)
+	agent.model = *model
+	agent.cfg.PromptCache = false
+	agent.cfg.MaxToolCalls = 2
+
+	ctx, outcome := agent.startTask(t.Context(), nil, "read it three times", systemPrompt(agent.mode))
+	if outcome.Status != OutcomeBudgetExhausted || outcome.ToolCalls != 2 || len(*requests) != 1 {
+		t.Fatalf("outcome = %s, %d requests", outcome, len(*requests))
+	}
+	toolMsgs := []openai.ChatCompletionMessage{}
+	for _, msg := range ctx.history {
+		if msg.Role == "tool" {
+			toolMsgs = append(toolMsgs, msg)
+		}
+	}
+	if len(toolMsgs) != 3 {
+		t.Fatalf("%d tool messages, every call needs one", len(toolMsgs))
+	}
+	for i, msg := range toolMsgs {
+		if over := strings.Contains(msg.Content, string(ToolBudgetExhausted)); over != (i == 2) || msg.ToolCallID != fmt.Sprintf("call_%d", i) {
+			t.Errorf("tool message %d = %+v", i, msg)
+		}
+	}
+}
+
+func TestBaseAgent_truncatedToolCallsDropped(t *testing.T) {
+	t.Chdir(t.TempDir())
+	truncated := toolCallResponse("call_1", "read_file", `{"file":"READ`)
+	truncated.chunks[1].Choices[0].FinishReason = openai.FinishReasonLength
+	model, requests := newFakeModel(t, truncated, textResponse("done", openai.FinishReasonStop))
+	agent := newTestAgent(t, t.TempDir())
+	agent.model = *model
+	agent.cfg.PromptCache = false
+
+	outcome := agent.NewUserTask(t.Context(), "read it")
+	if outcome.Status != OutcomeCompleted || outcome.ToolCalls != 0 {
+		t.Fatalf("outcome = %s", outcome)
+	}
+	found := false
+	for _, msg := range (*requests)[1].Messages {
+		if len(msg.ToolCalls) != 0 {
+			t.Errorf("the truncated tool call is sent again: %+v", msg)
+		}
+		found = found || (msg.Role == "user" && strings.HasPrefix(msg.Content, "Your previous response was cut off"))
+	}
+	if !found {
+		t.Error("the continue prompt is not sent after the truncated response")
+	}
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// errTruncatedStream is returned when the response stream ends before the model finished it.
+var errTruncatedStream = errors.New("the response stream ended without a finish reason")
+
+type RetryConfig struct {
+	// MaxRetries is the number of times a failed request is retried, 0 disables retrying.
+	MaxRetries int `json:"max_retries"`
+	// BaseDelayMs is the backoff before the first retry in milliseconds, it doubles for every retry.
+	BaseDelayMs int `json:"base_delay_ms"`
+	// MaxDelayMs caps the backoff and the delay asked by the Retry-After header in milliseconds.
+	MaxDelayMs int `json:"max_delay_ms"`
+}
+
+// delay returns the backoff before the retry after the failed attempt, starting from 0. The backoff
+// doubles for every attempt with a random jitter of up to half of it, the Retry-After delay is used
+// instead when the server asks for one.
+func (cfg RetryConfig) delay(attempt int, retryAfter time.Duration) time.Duration {
+	maxDelay := time.Duration(cfg.MaxDelayMs) * time.Millisecond
+	if retryAfter > 0 {
+		return min(retryAfter, maxDelay)
+	}
+	backoff := time.Duration(cfg.BaseDelayMs) * time.Millisecond
+	for i := 0; i < attempt && backoff < maxDelay; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxDelay)
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + rand.N(backoff-half+1)
+}
+
+// retryable tells if the failed request may succeed when it is sent again: rate limits, server
+// errors, network failures and truncated response streams are retried, other errors are not.
+func retryable(err error) bool {
+	// the http client wraps every error in url.Error, a net.Error, so the canceled requests are checked first
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, errTruncatedStream) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 {
+		return retryableStatus(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return retryableStatus(reqErr.HTTPStatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// textStream sends the response text of an attempt as TextDeltaEvents. When the turn is retried the
+// text streamed by the failed attempt is not sent again: the retried response is held back while it
+// repeats that text, and if it differs the text is discarded and the response is sent from its start.
+type textStream struct {
+	ctx *AgentContext
+	// shown is the text streamed by the failed attempts, matched is the length of its prefix repeated.
+	shown   string
+	matched int
+	text    strings.Builder
+}
+
+func (s *textStream) add(delta string) {
+	s.text.WriteString(delta)
+	if s.matched < len(s.shown) {
+		n := min(len(delta), len(s.shown)-s.matched)
+		if s.shown[s.matched:s.matched+n] != delta[:n] {
+			s.discard()
+			return
+		}
+		s.matched += n
+		delta = delta[n:]
+	}
+	if delta != "" {
+		s.ctx.emit(TextDeltaEvent{Text: delta})
+	}
+}
+
+// discard withdraws the text of the failed attempts and sends the response received so far.
+func (s *textStream) discard() {
+	s.ctx.emit(ResponseDiscardedEvent{Text: s.shown})
+	s.shown, s.matched = "", 0
+	if s.text.Len() != 0 {
+		s.ctx.emit(TextDeltaEvent{Text: s.text.String()})
+	}
+}
+
+// end records the text streamed for the turn, a complete response shorter than the text of the
+// failed attempts discards it.
+func (s *textStream) end(complete bool) {
+	if complete && s.matched < len(s.shown) {
+		s.discard()
+	}
+	if s.matched < len(s.shown) {
+		s.ctx.streamed = s.shown
+	} else {
+		s.ctx.streamed = s.text.String()
+	}
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestRetryConfig_delay(t *testing.T) {
+	cfg := RetryConfig{MaxRetries: 5, BaseDelayMs: 100, MaxDelayMs: 1000}
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 0, retryAfter: 300 * time.Millisecond, min: 300 * time.Millisecond, max: 300 * time.Millisecond},
+		{attempt: 0, retryAfter: time.Minute, min: time.Second, max: time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := cfg.delay(tt.attempt, tt.retryAfter); got < tt.min || got > tt.max {
+				t.Errorf("delay(%d, %v) = %v, want in [%v, %v]", tt.attempt, tt.retryAfter, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadGateway}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, false},
+		{&openai.APIError{HTTPStatusCode: http.StatusUnauthorized}, false},
+		{&openai.RequestError{HTTPStatusCode: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("read body: %w", syscall.ECONNRESET), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{io.ErrUnexpectedEOF, true},
+		{errTruncatedStream, true},
+		{fmt.Errorf("send: %w", errors.Join(context.Canceled, &net.OpError{Op: "read"})), false},
+		{errors.New("invalid request"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBaseAgent_runTurnRetry(t *testing.T) {
+	done := textResponse("done", openai.FinishReasonStop)
+	truncated := textResponse("do", "")
+	truncated.chunks = truncated.chunks[:1]
+	rateLimited := sseResponse{status: http.StatusTooManyRequests, header: http.Header{"Retry-After": []string{"1"}}}
+	tests := []struct {
+		name         string
+		responses    []sseResponse
+		wantStatus   OutcomeStatus
+		wantRequests int
+	}{
+		{
+			name:         "server error and rate limit",
+			responses:    []sseResponse{{status: http.StatusInternalServerError}, rateLimited, done},
+			wantStatus:   OutcomeCompleted,
+			wantRequests: 3,
+		},
+		{
+			name:         "truncated stream",
+			responses:    []sseResponse{truncated, done},
+			wantStatus:   OutcomeCompleted,
+			wantRequests: 2,
+		},
+		{
+			name:         "not retryable",
+			responses:    []sseResponse{{status: http.StatusUnauthorized}, done},
+			wantStatus:   OutcomeError,
+			wantRequests: 1,
+		},
+		{
+			name:         "retries exhausted",
+			responses:    []sseResponse{{status: http.StatusBadGateway}},
+			wantStatus:   OutcomeError,
+			wantRequests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			model, requests := newFakeModel(t, tt.responses...)
+			agent := newTestAgent(t, t.TempDir())
+			agent.model = *model
+			agent.cfg.Retry = RetryConfig{MaxRetries: 2, BaseDelayMs: 1, MaxDelayMs: 5}
+
+			outcome := agent.NewUserTask(t.Context(), "hi")
+			if outcome.Status != tt.wantStatus || len(*requests) != tt.wantRequests {
+				t.Fatalf("outcome = %s with %d requests, want %s with %d requests", outcome, len(*requests), tt.wantStatus, tt.wantRequests)
+			}
+			// the retried turn is one iteration and the failed attempts leave no message in the history
+			if outcome.Iterations != 1 {
+				t.Errorf("iterations = %d, want 1", outcome.Iterations)
+			}
+			if tt.wantStatus == OutcomeCompleted && outcome.Answer != "done" {
+				t.Errorf("answer = %q, want done", outcome.Answer)
+			}
+		})
+	}
+}
+
+func TestBaseAgent_retryStreamedText(t *testing.T) {
+	truncated := func(content string) sseResponse {
+		resp := textResponse(content, "")
+		resp.chunks = resp.chunks[:1]
+		return resp
+	}
+	tests := []struct {
+		name      string
+		responses []sseResponse
+		want      []string
+	}{
+		{
+			name:      "retry repeats the streamed text",
+			responses: []sseResponse{truncated("do"), textResponse("done", openai.FinishReasonStop)},
+			want:      []string{"do", "ne"},
+		},
+		{
+			name:      "retry differs",
+			responses: []sseResponse{truncated("hi"), textResponse("done", openai.FinishReasonStop)},
+			want:      []string{"hi", "discard hi", "done"},
+		},
+		{
+			name:      "retry is shorter",
+			responses: []sseResponse{truncated("done, and"), textResponse("done", openai.FinishReasonStop)},
+			want:      []string{"done, and", "discard done, and", "done"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			model, _ := newFakeModel(t, tt.responses...)
+			agent := newTestAgent(t, t.TempDir())
+			agent.model = *model
+			agent.cfg.Retry = RetryConfig{MaxRetries: 2, BaseDelayMs: 1, MaxDelayMs: 5}
+			got := []string{}
+			agent.SetEventHandler(func(event Event) {
+				switch e := event.(type) {
+				case TextDeltaEvent:
+					got = append(got, e.Text)
+				case ResponseDiscardedEvent:
+					got = append(got, "discard "+e.Text)
+				}
+			})
+			if outcome := agent.NewUserTask(t.Context(), "hi"); outcome.Answer != "done" {
+				t.Fatalf("outcome = %s, answer %q", outcome, outcome.Answer)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("streamed = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
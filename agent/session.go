@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	llmctx "llm_dev/context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Session is the saved state of an agent, the task history and the state of every context manager.
+type Session struct {
+	ID      string      `json:"id"`
+	Root    string      `json:"root"`
+	Title   string      `json:"title"`
+	Created time.Time   `json:"created"`
+	Updated time.Time   `json:"updated"`
+	Mode    llmctx.Mode `json:"mode"`
+
+	History []TaskMemory `json:"history"`
+	// Context maps the context manager name to its saved state.
+	Context map[string]json.RawMessage `json:"context"`
+}
+
+func newSessionID(now time.Time) string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", now.Format("20060102-150405"), hex.EncodeToString(suffix))
+}
+
+// SessionStore saves every session as a JSON file named by the session id in Dir.
+type SessionStore struct {
+	Dir string
+}
+
+func NewSessionStore(dir string) *SessionStore {
+	return &SessionStore{Dir: dir}
+}
+
+func (store *SessionStore) path(id string) string {
+	return filepath.Join(store.Dir, id+".json")
+}
+
+// Save writes the session to a temporary file first, so an interrupted save never corrupts the session.
+func (store *SessionStore) Save(session *Session) error {
+	if err := os.MkdirAll(store.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := store.path(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.path(session.ID))
+}
+
+func (store *SessionStore) Load(id string) (*Session, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid session id %q", id)
+	}
+	data, err := os.ReadFile(store.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("session %s not found in %s", id, store.Dir)
+		}
+		return nil, err
+	}
+	session := Session{}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("parse session %s failed: %w", id, err)
+	}
+	return &session, nil
+}
+
+// List returns the saved sessions, the most recently updated first.
+func (store *SessionStore) List() ([]Session, error) {
+	entries, err := os.ReadDir(store.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	res := []Session{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		session, err := store.Load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			log.Warn().Err(err).Str("file", entry.Name()).Msg("load session fail")
+			continue
+		}
+		res = append(res, *session)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if !res[i].Updated.Equal(res[j].Updated) {
+			return res[i].Updated.After(res[j].Updated)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// StartSession starts a new session saved in store after every task.
+func (agent *BaseAgent) StartSession(store *SessionStore) *Session {
+	now := time.Now()
+	agent.store = store
+	agent.session = &Session{
+		ID:      newSessionID(now),
+		Root:    agent.root,
+		Mode:    agent.mode,
+		Created: now,
+		Updated: now,
+	}
+	return agent.session
+}
+
+// ResumeSession loads the session from store and restores the task history and the context managers.
+func (agent *BaseAgent) ResumeSession(store *SessionStore, id string) (*Session, error) {
+	session, err := store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Root != agent.root {
+		return nil, fmt.Errorf("session %s belongs to codebase %s, not %s", id, session.Root, agent.root)
+	}
+	for _, named := range agent.ctxMgrs {
+		state, exist := session.Context[named.Name]
+		if !exist || len(state) == 0 {
+			continue
+		}
+		if err := named.Mgr.RestoreState(state); err != nil {
+			return nil, fmt.Errorf("restore %s context failed: %w", named.Name, err)
+		}
+	}
+	if session.Mode != "" {
+		if err := agent.SetMode(session.Mode); err != nil {
+			return nil, err
+		}
+	}
+	agent.history = session.History
+	agent.store = store
+	agent.session = session
+	return session, nil
+}
+
+func (agent *BaseAgent) Session() *Session {
+	return agent.session
+}
+
+// saveSession saves the current state to the session store, it does nothing if no session is started.
+func (agent *BaseAgent) saveSession() {
+	if agent.session == nil || agent.store == nil {
+		return
+	}
+	session := agent.session
+	session.Updated = time.Now()
+	session.History = agent.history
+	if session.Title == "" && len(agent.history) != 0 {
+		session.Title = agent.history[0].Prompt
+	}
+	// the state of the managers disabled by the current profile is kept for later resumes
+	if session.Context == nil {
+		session.Context = make(map[string]json.RawMessage, len(agent.ctxMgrs))
+	}
+	for _, named := range agent.ctxMgrs {
+		state, err := named.Mgr.SaveState()
+		if err != nil {
+			log.Error().Err(err).Str("context", named.Name).Msg("save context state fail")
+			continue
+		}
+		if state != nil {
+			session.Context[named.Name] = state
+		}
+	}
+	if err := agent.store.Save(session); err != nil {
+		log.Error().Err(err).Str("session", session.ID).Msg("save session fail")
+	}
+}
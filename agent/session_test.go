@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"encoding/json"
+	"llm_dev/codebase/impl"
+	llmctx "llm_dev/context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAgent(t *testing.T, root string) BaseAgent {
+	t.Helper()
+	agent := BaseAgent{
+		root:    root,
+		cfg:     DefaultAgentConfig(),
+		mode:    llmctx.ModeAsk,
+		buildOp: &impl.BuildCodeBaseCtxOps{RootPath: root},
+	}
+	if err := agent.newCtxMgrs(); err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore(filepath.Join(t.TempDir(), "sessions"))
+	now := time.Now()
+	sessions := []*Session{
+		{ID: "s1", Root: "/code", Title: "first", Updated: now.Add(-time.Hour)},
+		{ID: "s2", Root: "/code", Title: "second", Updated: now},
+	}
+	for _, session := range sessions {
+		if err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(store.Dir, "broken.json"), []byte("{"), 0644)
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, session := range list {
+		ids = append(ids, session.ID)
+	}
+	if want := []string{"s2", "s1"}; !slices.Equal(ids, want) {
+		t.Errorf("List() = %v, want %v", ids, want)
+	}
+	for _, id := range []string{"missing", "../s1", ""} {
+		if _, err := store.Load(id); err == nil {
+			t.Errorf("Load(%q) expects an error", id)
+		}
+	}
+	empty, err := NewSessionStore(filepath.Join(t.TempDir(), "none")).List()
+	if err != nil || len(empty) != 0 {
+		t.Errorf("List() of a missing dir = %v, %v", empty, err)
+	}
+}
+
+func TestBaseAgent_ResumeSession(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "notes.md"), []byte("a\nb\nc\n"), 0644)
+	store := NewSessionStore(t.TempDir())
+
+	agent := newTestAgent(t, root)
+	session := agent.StartSession(store)
+	loadLines := ""
+	for _, tool := range agent.fileCtx.GetToolDef() {
+		if tool.Name == "load_lines" {
+			loadLines, _ = tool.Handler(`{"file":"notes.md","start_line":1,"end_line":2}`)
+		}
+	}
+	if !strings.Contains(loadLines, "success") {
+		t.Fatalf("load lines failed: %s", loadLines)
+	}
+	agent.history = []TaskMemory{{Prompt: "read notes", Summary: "- notes has 3 lines", Files: []string{"notes.md"}}}
+	agent.saveSession()
+
+	resumed := newTestAgent(t, root)
+	got, err := resumed.ResumeSession(store, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "read notes" || len(resumed.History()) != 1 || resumed.History()[0].Summary != "- notes has 3 lines" {
+		t.Errorf("history is not resumed: %+v", got)
+	}
+	want, _ := agent.fileCtx.SaveState()
+	state, _ := resumed.fileCtx.SaveState()
+	if !json.Valid(state) || string(state) != string(want) {
+		t.Errorf("file context is not resumed\ngot:  %s\nwant: %s", state, want)
+	}
+
+	other := newTestAgent(t, t.TempDir())
+	if _, err := other.ResumeSession(store, session.ID); err == nil {
+		t.Errorf("ResumeSession() of another codebase expects an error")
+	}
+}
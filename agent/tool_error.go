@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type ToolErrorType string
+
+const (
+	// ToolNotFound means the model called a tool not provided in the current mode.
+	ToolNotFound ToolErrorType = "tool_not_found"
+	// InvalidArguments means the arguments do not match the parameters schema of the tool.
+	InvalidArguments ToolErrorType = "invalid_arguments"
+	// ToolFailed means the tool handler returned an error or panicked.
+	ToolFailed ToolErrorType = "tool_failed"
+	// ToolBudgetExhausted means the call is not run because the task used up its tool call budget.
+	ToolBudgetExhausted ToolErrorType = "budget_exhausted"
+)
+
+// ToolError is a failed tool call, it is sent back to the model as the content of the tool message,
+// every tool call of the assistant message needs a tool message or the next request is rejected.
+type ToolError struct {
+	Type    ToolErrorType `json:"type"`
+	Tool    string        `json:"tool"`
+	Message string        `json:"message"`
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Tool, e.Type, e.Message)
+}
+
+// content renders the error as the JSON content of the tool message.
+func (e *ToolError) content() string {
+	data, _ := json.Marshal(map[string]*ToolError{"error": e})
+	return string(data)
+}
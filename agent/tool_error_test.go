@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"llm_dev/model"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// toolCtxMgr provides tools that succeed, fail and panic.
+type toolCtxMgr struct{}
+
+func (mgr *toolCtxMgr) WriteContext(buf *bytes.Buffer)           {}
+func (mgr *toolCtxMgr) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (mgr *toolCtxMgr) RestoreState(state json.RawMessage) error { return nil }
+func (mgr *toolCtxMgr) GetToolDef() []model.ToolDef {
+	params := jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties:           map[string]jsonschema.Definition{"text": {Type: jsonschema.String}},
+		Required:             []string{"text"},
+	}
+	return []model.ToolDef{
+		{
+			FunctionDefinition: openai.FunctionDefinition{Name: "echo", Parameters: params},
+			Handler:            func(args string) (string, error) { return args, nil },
+		},
+		{
+			FunctionDefinition: openai.FunctionDefinition{Name: "fail", Parameters: params},
+			Handler:            func(args string) (string, error) { return "", errors.New("disk is full") },
+		},
+		{
+			FunctionDefinition: openai.FunctionDefinition{Name: "crash", Parameters: params},
+			Handler:            func(args string) (string, error) { panic("nil map") },
+		},
+	}
+}
+
+func TestAgentContext_toolCall(t *testing.T) {
+	ctx := NewAgentContext(nil, "task", &toolCtxMgr{})
+	tests := []struct {
+		name     string
+		call     openai.FunctionCall
+		want     string
+		wantType ToolErrorType
+		wantMsg  string
+	}{
+		{name: "success", call: openai.FunctionCall{Name: "echo", Arguments: `{"text":"hi"}`}, want: `{"text":"hi"}`},
+		{name: "unknown tool", call: openai.FunctionCall{Name: "apply_diff", Arguments: `{}`}, wantType: ToolNotFound, wantMsg: "available tools are [crash echo fail]"},
+		{name: "bad json", call: openai.FunctionCall{Name: "echo", Arguments: `{"text":`}, wantType: InvalidArguments, wantMsg: "not valid JSON"},
+		{name: "schema mismatch", call: openai.FunctionCall{Name: "echo", Arguments: `{"text":3}`}, wantType: InvalidArguments, wantMsg: "arguments.text must be string"},
+		{name: "handler error", call: openai.FunctionCall{Name: "fail", Arguments: `{"text":"x"}`}, wantType: ToolFailed, wantMsg: "disk is full"},
+		{name: "handler panic", call: openai.FunctionCall{Name: "crash", Arguments: `{"text":"x"}`}, wantType: ToolFailed, wantMsg: "nil map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ctx.toolCall(openai.ToolCall{ID: "call_1", Type: openai.ToolTypeFunction, Function: tt.call})
+			if msg.Role != "tool" || msg.ToolCallID != "call_1" {
+				t.Fatalf("tool message = %+v", msg)
+			}
+			if tt.wantType == "" {
+				if err != nil || msg.Content != tt.want {
+					t.Errorf("toolCall() = %q, %v, want %q", msg.Content, err, tt.want)
+				}
+				return
+			}
+			toolErr := &ToolError{}
+			if !errors.As(err, &toolErr) || toolErr.Type != tt.wantType {
+				t.Fatalf("toolCall() error = %v, want %s", err, tt.wantType)
+			}
+			content := map[string]ToolError{}
+			if err := json.Unmarshal([]byte(msg.Content), &content); err != nil {
+				t.Fatalf("tool message content %q is not JSON: %v", msg.Content, err)
+			}
+			got := content["error"]
+			if got.Type != tt.wantType || got.Tool != tt.call.Name || !strings.Contains(got.Message, tt.wantMsg) {
+				t.Errorf("tool message error = %+v, want %s containing %q", got, tt.wantType, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestBaseAgent_toolErrorSentBack(t *testing.T) {
+	t.Chdir(t.TempDir())
+	model, requests := newFakeModel(t,
+		toolCallResponse("call_1", "apply_diff", `{"file":"a.go"}`),
+		textResponse("apply_diff is not available in ask mode", openai.FinishReasonStop),
+	)
+	agent := newTestAgent(t, t.TempDir())
+	agent.model = *model
+	agent.cfg.PromptCache = false
+
+	outcome := agent.NewUserTask(t.Context(), "edit a.go")
+	if outcome.Status != OutcomeCompleted || len(*requests) != 2 {
+		t.Fatalf("outcome = %s with %d requests", outcome, len(*requests))
+	}
+	// the assistant tool call is followed by the error tool message in the next request
+	messages := (*requests)[1].Messages
+	for i, msg := range messages {
+		if len(msg.ToolCalls) == 0 {
+			continue
+		}
+		if i+1 >= len(messages) || messages[i+1].Role != "tool" || messages[i+1].ToolCallID != "call_1" {
+			t.Fatalf("no tool message answers the tool call: %+v", messages[i+1:])
+		}
+		if !strings.Contains(messages[i+1].Content, `"type":"tool_not_found"`) {
+			t.Errorf("tool message = %q, want tool_not_found error", messages[i+1].Content)
+		}
+		return
+	}
+	t.Fatal("the tool call is not in the request")
+}
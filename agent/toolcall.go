@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// toolResult is the tool message of a tool call and the error of the call, if it failed.
+type toolResult struct {
+	msg openai.ChatCompletionMessage
+	err error
+}
+
+// runToolCalls runs the tool calls of one response and returns the results in the order of the calls.
+// Consecutive read-only calls run concurrently with at most concurrency calls at a time, a call that
+// is not read-only waits for the calls before it and runs alone, so the writes keep their order.
+// The events of a batch of concurrent calls are sent before and after the batch, in the order of the calls.
+func (ctx *AgentContext) runToolCalls(calls []openai.ToolCall, concurrency int) []toolResult {
+	res := make([]toolResult, len(calls))
+	concurrency = max(concurrency, 1)
+	for start := 0; start < len(calls); {
+		end := start + 1
+		for end < len(calls) && ctx.readOnly(calls[start]) && ctx.readOnly(calls[end]) {
+			end++
+		}
+		for i := start; i < end; i++ {
+			ctx.emit(ToolCallStartedEvent{Call: calls[i], ReadOnly: ctx.readOnly(calls[i])})
+		}
+		if end-start == 1 || concurrency == 1 {
+			for i := start; i < end; i++ {
+				res[i].msg, res[i].err = ctx.toolCall(calls[i])
+			}
+		} else {
+			ctx.runConcurrently(calls[start:end], res[start:end], concurrency)
+		}
+		for i := start; i < end; i++ {
+			event := ToolCallFinishedEvent{Call: calls[i], Result: res[i].msg.Content}
+			errors.As(res[i].err, &event.Err)
+			ctx.emit(event)
+		}
+		start = end
+	}
+	return res
+}
+
+func (ctx *AgentContext) runConcurrently(calls []openai.ToolCall, res []toolResult, concurrency int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, call := range calls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res[i].msg, res[i].err = ctx.toolCall(call)
+		}()
+	}
+	wg.Wait()
+}
+
+// readOnly tells if the tool of the call is read-only, unknown tools fail without running anything.
+func (ctx *AgentContext) readOnly(call openai.ToolCall) bool {
+	def, exist := ctx.toolHandlerMap[call.Function.Name]
+	return !exist || def.ReadOnly
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"llm_dev/model"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// parallelCtxMgr records the running read and write tools.
+type parallelCtxMgr struct {
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+	events     []string
+}
+
+func (mgr *parallelCtxMgr) WriteContext(buf *bytes.Buffer)           {}
+func (mgr *parallelCtxMgr) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (mgr *parallelCtxMgr) RestoreState(state json.RawMessage) error { return nil }
+func (mgr *parallelCtxMgr) GetToolDef() []model.ToolDef {
+	handler := func(name string) model.ToolHandler {
+		return func(args string) (string, error) {
+			mgr.mu.Lock()
+			mgr.running++
+			mgr.maxRunning = max(mgr.maxRunning, mgr.running)
+			mgr.events = append(mgr.events, "start "+name+" "+args)
+			mgr.mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mgr.mu.Lock()
+			mgr.running--
+			mgr.events = append(mgr.events, "end "+name+" "+args)
+			mgr.mu.Unlock()
+			return name + " " + args, nil
+		}
+	}
+	return []model.ToolDef{
+		{FunctionDefinition: openai.FunctionDefinition{Name: "read"}, Handler: handler("read"), ReadOnly: true},
+		{FunctionDefinition: openai.FunctionDefinition{Name: "write"}, Handler: handler("write")},
+	}
+}
+
+func TestAgentContext_runToolCalls(t *testing.T) {
+	names := []string{"read", "read", "read", "read", "read", "write", "read", "read", "write", "write"}
+	calls := []openai.ToolCall{}
+	for i, name := range names {
+		calls = append(calls, openai.ToolCall{ID: fmt.Sprintf("call_%d", i), Function: openai.FunctionCall{Name: name, Arguments: fmt.Sprint(i)}})
+	}
+	tests := []struct {
+		name           string
+		concurrency    int
+		wantMaxRunning int
+	}{
+		{name: "sequential", concurrency: 1, wantMaxRunning: 1},
+		{name: "bounded pool", concurrency: 3, wantMaxRunning: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := &parallelCtxMgr{}
+			ctx := NewAgentContext(nil, "task", mgr)
+			res := ctx.runToolCalls(calls, tt.concurrency)
+			for i, r := range res {
+				if r.err != nil || r.msg.ToolCallID != calls[i].ID || r.msg.Content != fmt.Sprintf("%s %d", names[i], i) {
+					t.Errorf("result %d = %+v, %v", i, r.msg, r.err)
+				}
+			}
+			if mgr.maxRunning != tt.wantMaxRunning {
+				t.Errorf("max running tools = %d, want %d", mgr.maxRunning, tt.wantMaxRunning)
+			}
+			// a write tool starts after every call before it ends and ends before the calls after it start
+			for i, name := range names {
+				if name != "write" {
+					continue
+				}
+				start := slices.Index(mgr.events, fmt.Sprintf("start write %d", i))
+				if mgr.events[start+1] != fmt.Sprintf("end write %d", i) {
+					t.Errorf("write %d is not serialized: %v", i, mgr.events)
+				}
+				for j := range i {
+					if slices.Index(mgr.events, fmt.Sprintf("end %s %d", names[j], j)) > start {
+						t.Errorf("write %d starts before call %d ends: %v", i, j, mgr.events)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// TokenUsage accumulates the token usage reported by the model across the requests of a task.
+type TokenUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CachedTokens     int `json:"cached_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (usage *TokenUsage) add(u *openai.Usage) {
+	if u == nil {
+		return
+	}
+	usage.Requests++
+	usage.PromptTokens += u.PromptTokens
+	usage.CompletionTokens += u.CompletionTokens
+	if u.PromptTokensDetails != nil {
+		usage.CachedTokens += u.PromptTokensDetails.CachedTokens
+	}
+}
+
+func (usage TokenUsage) String() string {
+	res := fmt.Sprintf("%d requests, %d prompt tokens, %d completion tokens", usage.Requests, usage.PromptTokens, usage.CompletionTokens)
+	if usage.PromptTokens != 0 {
+		res += fmt.Sprintf(", %d cached prompt tokens (%d%%)", usage.CachedTokens, usage.CachedTokens*100/usage.PromptTokens)
+	}
+	return res
+}
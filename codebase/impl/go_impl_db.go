@@ -10,10 +10,13 @@ import (
 	"llm_dev/codebase/common"
 	"llm_dev/database"
 	"llm_dev/utils"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	ignore "github.com/sabhiram/go-gitignore"
@@ -37,36 +40,32 @@ const (
 `
 )
 
-var typeTSQuery *common.TSQuery
-var nameTSQuery *common.TSQuery
-var varTSQuery *common.TSQuery
+// The queries of the Go definitions are built once and shared by the parsing goroutines, a query is only
+// read by the query cursors created per call, so it is safe for concurrent use. They live as long as the process.
+var (
+	tsQueryOnce sync.Once
+	typeTSQuery *common.TSQuery
+	nameTSQuery *common.TSQuery
+	varTSQuery  *common.TSQuery
+)
 
-func InitTSQuery() {
-	var err error
-	lang := tree_sitter.NewLanguage(golang.Language())
-	typeTSQuery, err = common.NewTSQuery(typeQueryStr, lang)
-	if err != nil {
-		log.Fatal().Err(err).Msg("init query failed")
-	}
-	nameTSQuery, err = common.NewTSQuery(nameQueryStr, lang)
-	if err != nil {
-		log.Fatal().Err(err).Msg("init query failed")
-	}
-	varTSQuery, err = common.NewTSQuery(varSpecQueryStr, lang)
-	if err != nil {
-		log.Fatal().Err(err).Msg("init query failed")
-	}
-}
-func CloseTSQuery() {
-	if typeTSQuery != nil {
-		typeTSQuery.Close()
-	}
-	if nameTSQuery != nil {
-		nameTSQuery.Close()
-	}
-	if varTSQuery != nil {
-		varTSQuery.Close()
-	}
+func initTSQuery() {
+	tsQueryOnce.Do(func() {
+		var err error
+		lang := tree_sitter.NewLanguage(golang.Language())
+		typeTSQuery, err = common.NewTSQuery(typeQueryStr, lang)
+		if err != nil {
+			log.Fatal().Err(err).Msg("init query failed")
+		}
+		nameTSQuery, err = common.NewTSQuery(nameQueryStr, lang)
+		if err != nil {
+			log.Fatal().Err(err).Msg("init query failed")
+		}
+		varTSQuery, err = common.NewTSQuery(varSpecQueryStr, lang)
+		if err != nil {
+			log.Fatal().Err(err).Msg("init query failed")
+		}
+	})
 }
 
 type TypeInfo struct {
@@ -111,6 +110,7 @@ type Definition struct {
 }
 
 func NewDef(node *tree_sitter.Node, data []byte) []Definition {
+	initTSQuery()
 	defs := []Definition{}
 	def := Definition{}
 	Kind := node.Kind()
@@ -305,15 +305,21 @@ type FileDirInfo struct {
 	UsedDefs []Definition
 }
 
-func (fd *FileDirInfo) GetSummary() map[string]utils.FileContent {
+type FileSummary struct {
+	RelFile string
+	Content utils.FileContent
+}
+
+// GetSummary returns the summary of the used definitions grouped by file, sorted by file path.
+func (fd *FileDirInfo) GetSummary() []FileSummary {
 	defsByFile := fd.getDefByFile()
-	res := make(map[string]utils.FileContent, len(defsByFile))
-	for file, defs := range defsByFile {
+	res := make([]FileSummary, 0, len(defsByFile))
+	for _, file := range slices.Sorted(maps.Keys(defsByFile)) {
 		fc := utils.FileContent{}
-		for _, def := range defs {
+		for _, def := range defsByFile[file] {
 			fc.AddChunk(def.Summary)
 		}
-		res[file] = fc
+		res = append(res, FileSummary{RelFile: file, Content: fc})
 	}
 	return res
 }
@@ -374,8 +380,6 @@ func (op *BuildCodeBaseCtxOps) GenAllDefs() {
 		}
 		goFiles = append(goFiles, path)
 	}
-	InitTSQuery()
-	defer CloseTSQuery()
 	for _, file := range goFiles {
 		fileAlldefs := []Definition{}
 		ctx := common.WalkFileStaticAst(file, op.astCtxHandler)
@@ -387,6 +391,22 @@ func (op *BuildCodeBaseCtxOps) GenAllDefs() {
 	}
 }
 
+// ParseFileDefs parses the current content of the file and returns its definitions,
+// it does not read or write the Defs collection.
+func (op *BuildCodeBaseCtxOps) ParseFileDefs(relPath string) ([]Definition, error) {
+	file := filepath.Join(op.RootPath, relPath)
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	res := []Definition{}
+	ctx := common.WalkFileStaticAst(file, op.astCtxHandler)
+	for value := range ctx.OutputChan {
+		defs := common.GetMapas[[]Definition](value, "defs")
+		res = append(res, defs...)
+	}
+	return res, nil
+}
+
 func (op *BuildCodeBaseCtxOps) SetMinPreFix() {
 	usedDef := make(map[string]*Definition)
 	collection := op.Db.Collection("Used")
@@ -744,15 +764,20 @@ type FileTreeCtx struct {
 func (op *BuildCodeBaseCtxOps) WalkProjectFileTree() <-chan FileTreeCtx {
 	outputChan := make(chan FileTreeCtx, 10)
 	go func() {
+		defer close(outputChan)
 		ig, err := ignore.CompileIgnoreFile(filepath.Join(op.RootPath, ".gitignore"))
 		if err != nil {
 			log.Error().Msgf("compile ignore failed")
-			return
 		}
 		walkDirFunc := func(path string, d fs.DirEntry, err error) error {
-			keep := common.NewFilter(path, d).
-				FilterSymlink().
-				FilterGitIgnore(op.RootPath, ig).Keep()
+			if err != nil {
+				return nil
+			}
+			filter := common.NewFilter(path, d).FilterSymlink()
+			if ig != nil {
+				filter.FilterGitIgnore(op.RootPath, ig)
+			}
+			keep := filter.Keep()
 			if !keep {
 				if d.IsDir() {
 					return filepath.SkipDir
@@ -767,7 +792,6 @@ func (op *BuildCodeBaseCtxOps) WalkProjectFileTree() <-chan FileTreeCtx {
 			return nil
 		}
 		filepath.WalkDir(op.RootPath, walkDirFunc)
-		close(outputChan)
 	}()
 	return outputChan
 }
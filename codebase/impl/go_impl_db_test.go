@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"llm_dev/codebase/common"
 	"llm_dev/database"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -173,3 +177,54 @@ func TestFileOutline(t *testing.T) {
 		// }
 	})
 }
+
+func TestBuildCodeBaseCtxOps_ParseFileDefs(t *testing.T) {
+	t.Run("parse definitions of current file content", func(t *testing.T) {
+		root := t.TempDir()
+		src := "package a\n\ntype T struct{}\n\nfunc (t *T) M() {\n}\n\nvar v int\n"
+		os.WriteFile(filepath.Join(root, "a.go"), []byte(src), 0644)
+		op := BuildCodeBaseCtxOps{RootPath: root}
+		got, err := op.ParseFileDefs("a.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]string{
+			"a": {"package", "a"},
+			"T": {"type", "T"},
+			"M": {"method", "M", "T"},
+			"v": {"var", "v", "int"},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("ParseFileDefs returned %d definitions, want %d", len(got), len(want))
+		}
+		for _, def := range got {
+			if !reflect.DeepEqual(def.Keyword, want[def.Identifier]) || def.RelFile != "a.go" {
+				t.Errorf("unexpected definition %+v", def)
+			}
+		}
+		if _, err := op.ParseFileDefs("missing.go"); err == nil {
+			t.Errorf("parse missing file should fail")
+		}
+	})
+	t.Run("parse concurrently", func(t *testing.T) {
+		root := t.TempDir()
+		src := "package a\n\ntype T struct{}\n\nfunc (t *T) M() {\n}\n\nvar v int\n"
+		os.WriteFile(filepath.Join(root, "a.go"), []byte(src), 0644)
+		op := BuildCodeBaseCtxOps{RootPath: root}
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 20 {
+					defs, err := op.ParseFileDefs("a.go")
+					if err != nil || len(defs) != 4 {
+						t.Errorf("ParseFileDefs() = %d definitions, %v", len(defs), err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,203 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"llm_dev/database"
+	"llm_dev/model"
+	"llm_dev/utils"
+	"path/filepath"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	embeddingBatchSize = 32
+	maxEmbeddingText   = 4000
+)
+
+var SymbolKinds = []string{"function", "method", "type", "var"}
+
+type DefEmbedding struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"` // Maps to MongoDB _id
+	DefID   primitive.ObjectID
+	RelFile string
+	Model   string
+	Vector  []float32
+	// Generation identifies the GenAllEmbeddings run which wrote the embedding.
+	Generation primitive.ObjectID
+}
+
+type ScoredDef struct {
+	Def   Definition
+	Score float32
+}
+
+func (op *BuildCodeBaseCtxOps) embeddingText(def Definition) (string, error) {
+	content, err := utils.ReadRange(filepath.Join(op.RootPath, def.RelFile), def.Content)
+	if err != nil {
+		return "", err
+	}
+	if len(content) > maxEmbeddingText {
+		content = content[:maxEmbeddingText]
+	}
+	return fmt.Sprintf("file: %s\n%s", def.RelFile, content), nil
+}
+
+// GenAllEmbeddings computes the embedding of every definition in the Defs collection
+// and replaces the embeddings stored for the embedder model. The new embeddings are staged under
+// their own model key and only replace the old ones once every batch succeeds, a failed run keeps
+// the old index.
+func (op *BuildCodeBaseCtxOps) GenAllEmbeddings(embedder model.Embedder) error {
+	filter := database.NewFilter()
+	filter.AddFilter("keyword.0", database.NewFilterKV(database.In, SymbolKinds))
+	defs := op.FindDefs(filter.Build())
+	collection := op.Db.Collection("Embeddings")
+	generation := primitive.NewObjectID()
+	staging := fmt.Sprintf("%s#staging-%s", embedder.Name(), generation.Hex())
+	if err := op.stageEmbeddings(embedder, defs, staging, generation); err != nil {
+		if _, cleanErr := collection.DeleteMany(context.TODO(), bson.M{"model": staging}); cleanErr != nil {
+			log.Error().Err(cleanErr).Str("model", staging).Msg("delete staged embeddings fail")
+		}
+		return err
+	}
+	_, err := collection.UpdateMany(context.TODO(), bson.M{"model": staging}, bson.M{"$set": bson.M{"model": embedder.Name()}})
+	if err != nil {
+		return err
+	}
+	_, err = collection.DeleteMany(context.TODO(), bson.M{"model": embedder.Name(), "generation": bson.M{database.Ne: generation}})
+	return err
+}
+
+// stageEmbeddings embeds the definitions batch by batch and stores them under the staging model key.
+func (op *BuildCodeBaseCtxOps) stageEmbeddings(embedder model.Embedder, defs []Definition, staging string, generation primitive.ObjectID) error {
+	collection := op.Db.Collection("Embeddings")
+	for start := 0; start < len(defs); start += embeddingBatchSize {
+		batch := defs[start:min(start+embeddingBatchSize, len(defs))]
+		texts := make([]string, 0, len(batch))
+		batchDefs := make([]Definition, 0, len(batch))
+		for _, def := range batch {
+			text, err := op.embeddingText(def)
+			if err != nil {
+				log.Error().Err(err).Any("def", def.Identifier).Msg("read definition content fail")
+				continue
+			}
+			texts = append(texts, text)
+			batchDefs = append(batchDefs, def)
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		vectors, err := embedder.Embed(texts)
+		if err != nil {
+			return err
+		}
+		if len(vectors) != len(batchDefs) {
+			return fmt.Errorf("%d embeddings returned for %d definitions", len(vectors), len(batchDefs))
+		}
+		embeddings := make([]DefEmbedding, len(batchDefs))
+		for i, def := range batchDefs {
+			embeddings[i] = DefEmbedding{
+				DefID:      def.ID,
+				RelFile:    def.RelFile,
+				Model:      staging,
+				Vector:     vectors[i],
+				Generation: generation,
+			}
+		}
+		_, err = collection.InsertMany(context.TODO(), ToAnySlice(embeddings))
+		if err != nil {
+			return err
+		}
+		log.Info().Int("count", start+len(batch)).Int("total", len(defs)).Msg("generate definition embeddings")
+	}
+	return nil
+}
+
+// HasEmbeddings tells if the semantic index is built for the embedder model, it is false without a database.
+func (op *BuildCodeBaseCtxOps) HasEmbeddings(modelName string) bool {
+	if op == nil || op.Db == nil {
+		return false
+	}
+	count, err := op.Db.Collection("Embeddings").CountDocuments(context.TODO(), bson.M{"model": modelName}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Error().Err(err).Str("model", modelName).Msg("count embeddings fail")
+		return false
+	}
+	return count != 0
+}
+
+func (op *BuildCodeBaseCtxOps) findEmbeddings(filter bson.M) []DefEmbedding {
+	collection := op.Db.Collection("Embeddings")
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		log.Error().Err(err).Any("filter", filter).Msgf("run find failed")
+		return nil
+	}
+	defer cursor.Close(context.TODO())
+	result := []DefEmbedding{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		log.Error().Err(err).Msg("parse result to []DefEmbedding failed")
+		return nil
+	}
+	return result
+}
+
+// SemanticSearch returns the topK definitions most similar to query by cosine similarity,
+// computed in memory over the embeddings of the embedder model.
+func (op *BuildCodeBaseCtxOps) SemanticSearch(embedder model.Embedder, query string, topK int) ([]ScoredDef, error) {
+	embeddings := op.findEmbeddings(bson.M{"model": embedder.Name()})
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("no embedding found for model %s, the semantic index is not built, run llm_dev embed", embedder.Name())
+	}
+	vectors, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	ranked := rankEmbeddings(vectors[0], embeddings, topK)
+	ids := make([]primitive.ObjectID, len(ranked))
+	for i, elem := range ranked {
+		ids[i] = elem.embedding.DefID
+	}
+	defs := op.FindDefs(bson.M{"_id": bson.M{database.In: ids}})
+	defByID := make(map[primitive.ObjectID]Definition, len(defs))
+	for _, def := range defs {
+		defByID[def.ID] = def
+	}
+	res := []ScoredDef{}
+	for _, elem := range ranked {
+		def, exist := defByID[elem.embedding.DefID]
+		if !exist {
+			continue
+		}
+		res = append(res, ScoredDef{Def: def, Score: elem.score})
+	}
+	return res, nil
+}
+
+type scoredEmbedding struct {
+	embedding *DefEmbedding
+	score     float32
+}
+
+func rankEmbeddings(query []float32, embeddings []DefEmbedding, topK int) []scoredEmbedding {
+	res := make([]scoredEmbedding, len(embeddings))
+	for i := range embeddings {
+		res[i] = scoredEmbedding{
+			embedding: &embeddings[i],
+			score:     model.CosineSimilarity(query, embeddings[i].Vector),
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].score > res[j].score
+	})
+	if len(res) > topK {
+		res = res[:topK]
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package impl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"llm_dev/database"
+	"llm_dev/model"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRankEmbeddings(t *testing.T) {
+	t.Run("rank embeddings by cosine similarity", func(t *testing.T) {
+		embedder := model.StubEmbedder{Dim: 64}
+		texts := []string{
+			"load file context for the agent",
+			"connect to mongodb and ping",
+			"retry request with exponential backoff",
+		}
+		vectors, err := embedder.Embed(append(texts, "retry backoff"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		embeddings := make([]DefEmbedding, len(texts))
+		for i := range texts {
+			embeddings[i] = DefEmbedding{RelFile: texts[i], Vector: vectors[i]}
+		}
+		got := rankEmbeddings(vectors[len(texts)], embeddings, 2)
+		if len(got) != 2 {
+			t.Fatalf("rankEmbeddings returned %d results, want 2", len(got))
+		}
+		if got[0].embedding.RelFile != texts[2] {
+			t.Errorf("top result = %s, want %s", got[0].embedding.RelFile, texts[2])
+		}
+	})
+}
+
+// failingEmbedder fails from the batch failAt on.
+type failingEmbedder struct {
+	model.StubEmbedder
+	calls  int
+	failAt int
+}
+
+func (e *failingEmbedder) Embed(texts []string) ([][]float32, error) {
+	e.calls++
+	if e.failAt > 0 && e.calls >= e.failAt {
+		return nil, errors.New("embedding service unavailable")
+	}
+	return e.StubEmbedder.Embed(texts)
+}
+
+func TestBuildCodeBaseCtxOps_GenAllEmbeddings(t *testing.T) {
+	database.InitDB()
+	defer database.CloseDB()
+	db := database.GetDBClient().Database("llm_dev_embedding_test")
+	defer db.Drop(context.TODO())
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.go"), []byte("package a\n\nfunc A() {\n}\n"), 0644)
+	defs := []Definition{}
+	for i := 0; i < 2*embeddingBatchSize; i++ {
+		defs = append(defs, Definition{
+			Identifier: fmt.Sprintf("A%d", i),
+			Keyword:    []string{"function", fmt.Sprintf("A%d", i)},
+			Content:    utils.Range{StartLine: 3, EndLine: 5},
+			RelFile:    "a.go",
+		})
+	}
+	if _, err := db.Collection("Defs").InsertMany(context.TODO(), ToAnySlice(defs)); err != nil {
+		t.Fatal(err)
+	}
+	op := BuildCodeBaseCtxOps{RootPath: root, Db: db}
+	count := func() int64 {
+		n, err := db.Collection("Embeddings").CountDocuments(context.TODO(), bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	embedder := &failingEmbedder{StubEmbedder: model.StubEmbedder{Dim: 8}}
+	if err := op.GenAllEmbeddings(embedder); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != int64(len(defs)) || !op.HasEmbeddings(embedder.Name()) {
+		t.Fatalf("%d embeddings, want %d", got, len(defs))
+	}
+
+	// the second batch fails, the previous index is kept as is
+	failing := &failingEmbedder{StubEmbedder: model.StubEmbedder{Dim: 8}, failAt: 2}
+	if err := op.GenAllEmbeddings(failing); err == nil {
+		t.Fatal("GenAllEmbeddings() expects an error")
+	}
+	if got := count(); got != int64(len(defs)) {
+		t.Errorf("%d embeddings after a failed run, want the %d of the previous index", got, len(defs))
+	}
+
+	// a successful run replaces the previous index
+	if err := op.GenAllEmbeddings(embedder); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != int64(len(defs)) {
+		t.Errorf("%d embeddings after the rebuild, want %d", got, len(defs))
+	}
+}
@@ -0,0 +1,104 @@
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"llm_dev/utils"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BudgetedContextMgr is a context manager whose content can be evicted to fit the token budget.
+type BudgetedContextMgr interface {
+	ContextMgr
+	// Priority decides the eviction order, content of lower priority managers is evicted first.
+	Priority() int
+	// Evict drops the least recently used content, it returns false if nothing can be dropped.
+	Evict() bool
+}
+
+type ContextBudget struct {
+	// Limit is the max tokens of the rendered context, 0 means no limit.
+	Limit   int
+	counter utils.TokenCounter
+}
+
+func NewContextBudget(limit int) ContextBudget {
+	return ContextBudget{
+		Limit:   limit,
+		counter: utils.EstimateCounter{},
+	}
+}
+
+type ContextUsage struct {
+	Used    int
+	Limit   int
+	Evicted int
+}
+
+func (usage ContextUsage) String() string {
+	if usage.Limit == 0 {
+		return fmt.Sprintf("%d tokens", usage.Used)
+	}
+	res := fmt.Sprintf("%d / %d tokens (%d%%)", usage.Used, usage.Limit, usage.Used*100/usage.Limit)
+	if usage.Evicted != 0 {
+		res += fmt.Sprintf(", %d items evicted", usage.Evicted)
+	}
+	return res
+}
+
+// WriteContext renders the context of every manager into buf. If the rendered context with the
+// reserved tokens exceeds the limit, content is evicted from the budgeted managers in priority
+// order until it fits or nothing can be evicted.
+func (budget *ContextBudget) WriteContext(buf *bytes.Buffer, mgrs []ContextMgr, reserved int) ContextUsage {
+	parts := make([]bytes.Buffer, len(mgrs))
+	tokens := make([]int, len(mgrs))
+	total := reserved
+	render := func(i int) {
+		parts[i].Reset()
+		mgrs[i].WriteContext(&parts[i])
+		total -= tokens[i]
+		tokens[i] = budget.counter.Count(parts[i].String())
+		total += tokens[i]
+	}
+	evictOrder := []int{}
+	for i, mgr := range mgrs {
+		render(i)
+		if _, ok := mgr.(BudgetedContextMgr); ok {
+			evictOrder = append(evictOrder, i)
+		}
+	}
+	sort.SliceStable(evictOrder, func(i, j int) bool {
+		return mgrs[evictOrder[i]].(BudgetedContextMgr).Priority() < mgrs[evictOrder[j]].(BudgetedContextMgr).Priority()
+	})
+
+	evicted := 0
+	for budget.Limit > 0 && total > budget.Limit {
+		progress := false
+		for _, i := range evictOrder {
+			if mgrs[i].(BudgetedContextMgr).Evict() {
+				render(i)
+				evicted++
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			log.Warn().Int("used", total).Int("limit", budget.Limit).Msg("context exceeds token budget, nothing left to evict")
+			break
+		}
+	}
+	for i := range parts {
+		buf.Write(parts[i].Bytes())
+	}
+	return ContextUsage{
+		Used:    total,
+		Limit:   budget.Limit,
+		Evicted: evicted,
+	}
+}
+
+func (budget *ContextBudget) Count(text string) int {
+	return budget.counter.Count(text)
+}
@@ -0,0 +1,93 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"llm_dev/model"
+	"strings"
+	"testing"
+)
+
+type fakeCtxMgr struct {
+	items    []string
+	priority int
+}
+
+func (mgr *fakeCtxMgr) WriteContext(buf *bytes.Buffer) {
+	buf.WriteString(strings.Join(mgr.items, " "))
+}
+func (mgr *fakeCtxMgr) GetToolDef() []model.ToolDef {
+	return nil
+}
+func (mgr *fakeCtxMgr) SaveState() (json.RawMessage, error) {
+	return nil, nil
+}
+func (mgr *fakeCtxMgr) RestoreState(state json.RawMessage) error {
+	return nil
+}
+func (mgr *fakeCtxMgr) Priority() int {
+	return mgr.priority
+}
+func (mgr *fakeCtxMgr) Evict() bool {
+	if len(mgr.items) == 0 {
+		return false
+	}
+	mgr.items = mgr.items[1:]
+	return true
+}
+
+type staticCtxMgr struct {
+	content string
+}
+
+func (mgr *staticCtxMgr) WriteContext(buf *bytes.Buffer) {
+	buf.WriteString(mgr.content)
+}
+func (mgr *staticCtxMgr) GetToolDef() []model.ToolDef {
+	return nil
+}
+func (mgr *staticCtxMgr) SaveState() (json.RawMessage, error) {
+	return nil, nil
+}
+func (mgr *staticCtxMgr) RestoreState(state json.RawMessage) error {
+	return nil
+}
+
+func TestContextBudget_WriteContext(t *testing.T) {
+	t.Run("no eviction under limit", func(t *testing.T) {
+		budget := NewContextBudget(100)
+		mgr := &fakeCtxMgr{items: []string{"a", "b", "c"}}
+		var buf bytes.Buffer
+		usage := budget.WriteContext(&buf, []ContextMgr{mgr}, 0)
+		if usage.Evicted != 0 || usage.Used != 3 || buf.String() != "a b c" {
+			t.Errorf("usage = %+v, context = %q", usage, buf.String())
+		}
+	})
+	t.Run("evict lower priority first", func(t *testing.T) {
+		budget := NewContextBudget(7)
+		static := &staticCtxMgr{content: "x y"}
+		low := &fakeCtxMgr{items: []string{"l1", "l2", "l3"}, priority: 0}
+		high := &fakeCtxMgr{items: []string{"h1", "h2"}, priority: 1}
+		var buf bytes.Buffer
+		usage := budget.WriteContext(&buf, []ContextMgr{static, high, low}, 1)
+		if usage.Used > 7 {
+			t.Errorf("usage %+v exceeds limit", usage)
+		}
+		if len(high.items) != 2 {
+			t.Errorf("high priority items should be kept, got %v", high.items)
+		}
+		if len(low.items) != 0 {
+			t.Errorf("low priority items should be evicted first, got %v", low.items)
+		}
+	})
+	t.Run("stop when nothing can be evicted", func(t *testing.T) {
+		budget := NewContextBudget(1)
+		static := &staticCtxMgr{content: "x y z"}
+		mgr := &fakeCtxMgr{items: []string{"a"}}
+		var buf bytes.Buffer
+		usage := budget.WriteContext(&buf, []ContextMgr{static, mgr}, 0)
+		if usage.Evicted != 1 || usage.Used != 3 {
+			t.Errorf("usage = %+v", usage)
+		}
+	})
+}
@@ -55,6 +55,46 @@ You should follow the good principles:
 
 `
 
+var planPrompt = `
+To help user with task, you make a plan to modify the codebase, the plan is executed later in edit mode.
+You can NOT modify any file in plan mode.
+
+# Instruction
+
+You should follow the following instructions:
+1. Examine the user's task, load the relevant context with the tools until you know exactly which files and definitions need to change.
+2. Submit the plan with the 'submit_plan' tool, each step is one small change of one file, name the file and definition it changes and describe the change.
+3. If the user asks to change the plan, submit the complete updated plan again, it replaces the previous one.
+
+`
+
+var submitPlan = openai.FunctionDefinition{
+	Name:   "submit_plan",
+	Strict: true,
+	Description: `
+Submit the plan for the user's task, it replaces the previously submitted plan.
+The plan is shown in the context and followed when the user switches to edit mode.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"goal": {
+				Type:        jsonschema.String,
+				Description: "one sentence describing what the plan accomplishes",
+			},
+			"steps": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type: jsonschema.String,
+				},
+				Description: "the ordered steps of the plan without numbering, e.g. [\"context/search.go: add the maxResult param to searchSymbol\"]",
+			},
+		},
+		Required: []string{"goal", "steps"},
+	},
+}
+
 var appleDiff = openai.FunctionDefinition{
 	Name:   "apply_diff",
 	Strict: true,
@@ -165,13 +205,60 @@ type Action struct {
 	Result  string
 }
 
+// Plan is the plan submitted in plan mode.
+type Plan struct {
+	Goal  string   `json:"goal"`
+	Steps []string `json:"steps"`
+}
+
+func (plan *Plan) write(buf *bytes.Buffer) {
+	buf.WriteString(fmt.Sprintf("# Plan\n\nGoal: %s\n\n", plan.Goal))
+	for i, step := range plan.Steps {
+		buf.WriteString(fmt.Sprintf("%d. %s\n", i+1, step))
+	}
+	buf.WriteByte('\n')
+}
+
 type BuildContextMgr struct {
+	mode    Mode
+	plan    *Plan
 	actions []Action
+	// changed are the files edited since the last ChangedFiles call.
+	changed []string
+}
+
+// SetMode decides the tools and the context of the manager, nothing is provided in ask mode.
+// A manager whose mode is never set works in edit mode.
+func (mgr *BuildContextMgr) SetMode(mode Mode) {
+	mgr.mode = mode
+}
+
+func (mgr *BuildContextMgr) currentMode() Mode {
+	if mgr.mode == "" {
+		return ModeEdit
+	}
+	return mgr.mode
 }
 
 func (mgr *BuildContextMgr) WriteContext(buf *bytes.Buffer) {
+	mode := mgr.currentMode()
+	if mode == ModeAsk {
+		return
+	}
+	if mode == ModePlan {
+		buf.WriteString("{PLAN}\n\n")
+		if mgr.plan == nil {
+			buf.WriteString("No plan is submitted yet.\n\n")
+		} else {
+			mgr.plan.write(buf)
+		}
+		buf.WriteString("{END OF PLAN}\n\n")
+		return
+	}
 	buf.WriteString("{EDIT ACTION}\n\n")
-	buf.WriteString(prompt)
+	if mgr.plan != nil {
+		mgr.plan.write(buf)
+	}
 	buf.WriteString("# Action Status\n\n")
 	for i, action := range mgr.actions {
 		buf.WriteString(fmt.Sprintf("- Action %d:\n", i))
@@ -183,6 +270,27 @@ func (mgr *BuildContextMgr) WriteContext(buf *bytes.Buffer) {
 	buf.WriteString("{END OF EDIT ACTION}\n\n")
 }
 
+func (mgr *BuildContextMgr) WriteStableContext(buf *bytes.Buffer) {
+	switch mgr.currentMode() {
+	case ModeAsk:
+		return
+	case ModePlan:
+		buf.WriteString("{PLAN INSTRUCTION}\n\n")
+		buf.WriteString(planPrompt)
+		buf.WriteString("{END OF PLAN INSTRUCTION}\n\n")
+		return
+	}
+	buf.WriteString("{EDIT ACTION INSTRUCTION}\n\n")
+	buf.WriteString(prompt)
+	buf.WriteString("{END OF EDIT ACTION INSTRUCTION}\n\n")
+}
+
+func (mgr *BuildContextMgr) ChangedFiles() []string {
+	res := mgr.changed
+	mgr.changed = nil
+	return res
+}
+
 func (mgr *BuildContextMgr) addAction(action Action) {
 	mgr.actions = append(mgr.actions, action)
 }
@@ -213,6 +321,7 @@ func (mgr *BuildContextMgr) GetToolDef() []model.ToolDef {
 			return "", err
 		}
 		fmt.Printf("Diff content:\n%s\n", args.Diff)
+		mgr.changed = append(mgr.changed, args.File)
 		return "apply the edit success", nil
 	}
 	// insert := func(argsStr string) (string, error) {
@@ -244,6 +353,29 @@ func (mgr *BuildContextMgr) GetToolDef() []model.ToolDef {
 	// 	res := fmt.Sprintf("File: %s\nLine: %d-%d\n```\n%s\n```\n", args.File, args.Startline, args.Endline, args.Content)
 	// 	return res, nil
 	// }
+	submitPlanHandler := func(argsStr string) (string, error) {
+		args := struct {
+			Goal  string
+			Steps []string
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		if len(args.Steps) == 0 {
+			return "submit plan failed, the plan has no step", nil
+		}
+		mgr.plan = &Plan{Goal: args.Goal, Steps: args.Steps}
+		return fmt.Sprintf("submit plan success, the plan has %d steps", len(args.Steps)), nil
+	}
+	switch mgr.currentMode() {
+	case ModeAsk:
+		return nil
+	case ModePlan:
+		return []model.ToolDef{
+			{FunctionDefinition: submitPlan, Handler: submitPlanHandler},
+		}
+	}
 	res := []model.ToolDef{
 		{FunctionDefinition: newAction, Handler: newActionHandler},
 		{FunctionDefinition: appleDiff, Handler: applyDiffFunc},
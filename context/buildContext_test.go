@@ -0,0 +1,61 @@
+package context
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildContextMgr_SetMode(t *testing.T) {
+	tests := []struct {
+		mode      Mode
+		wantTools []string
+		wantCtx   string
+	}{
+		{mode: ModeAsk, wantTools: []string{}, wantCtx: ""},
+		{mode: ModePlan, wantTools: []string{"submit_plan"}, wantCtx: "{PLAN}"},
+		{mode: ModeEdit, wantTools: []string{"declare_action", "apply_diff"}, wantCtx: "{EDIT ACTION}"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			mgr := BuildContextMgr{}
+			mgr.SetMode(tt.mode)
+			tools := []string{}
+			for _, tool := range mgr.GetToolDef() {
+				tools = append(tools, tool.Name)
+			}
+			if !slices.Equal(tools, tt.wantTools) {
+				t.Errorf("tools = %v, want %v", tools, tt.wantTools)
+			}
+			var buf bytes.Buffer
+			mgr.WriteContext(&buf)
+			if !strings.HasPrefix(buf.String(), tt.wantCtx) || tt.wantCtx == "" && buf.Len() != 0 {
+				t.Errorf("context = %q, want prefix %q", buf.String(), tt.wantCtx)
+			}
+		})
+	}
+}
+
+func TestBuildContextMgr_submitPlan(t *testing.T) {
+	mgr := BuildContextMgr{}
+	mgr.SetMode(ModePlan)
+	submit := mgr.GetToolDef()[0].Handler
+	res, err := submit(`{"goal":"add retry","steps":[]}`)
+	if err != nil || !strings.Contains(res, "no step") || mgr.plan != nil {
+		t.Fatalf("empty plan is accepted: %s, %v", res, err)
+	}
+	res, err = submit(`{"goal":"add retry","steps":["agent/retry.go: add backoff","agent/baseAgent.go: retry the stream"]}`)
+	if err != nil || !strings.Contains(res, "2 steps") {
+		t.Fatalf("submit plan failed: %s, %v", res, err)
+	}
+	want := "# Plan\n\nGoal: add retry\n\n1. agent/retry.go: add backoff\n2. agent/baseAgent.go: retry the stream\n\n"
+	for _, mode := range []Mode{ModePlan, ModeEdit} {
+		mgr.SetMode(mode)
+		var buf bytes.Buffer
+		mgr.WriteContext(&buf)
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%s mode context does not show the plan:\n%s", mode, buf.String())
+		}
+	}
+}
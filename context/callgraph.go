@@ -8,7 +8,11 @@ import (
 	"llm_dev/codebase/impl"
 	"llm_dev/model"
 	"llm_dev/utils"
+	"maps"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
@@ -129,7 +133,8 @@ func (mgr *CallGraphContextMgr) genReferenceOutput(usedDefs []impl.UsedDef) stri
 		defMap[useddef.File] = append(defMap[useddef.File], useddef)
 	}
 	buf.WriteString("# The following code use the definition\n\n")
-	for file, defs := range defMap {
+	for _, file := range slices.Sorted(maps.Keys(defMap)) {
+		defs := defMap[file]
 		fc := utils.FileContent{}
 		for _, usedef := range defs {
 			def := impl.Definition{
@@ -163,7 +168,8 @@ func (mgr *CallGraphContextMgr) genUseOutput(usedDefs []impl.UsedDef) string {
 		}
 	}
 	buf.WriteString("# Use Definition In the codebase\n\n")
-	for file, defs := range defMap {
+	for _, file := range slices.Sorted(maps.Keys(defMap)) {
+		defs := defMap[file]
 		fc := utils.FileContent{}
 		for _, usedef := range defs {
 			def := impl.Definition{
@@ -183,7 +189,11 @@ func (mgr *CallGraphContextMgr) genUseOutput(usedDefs []impl.UsedDef) string {
 	}
 	buf.WriteByte('\n')
 	buf.WriteString("# Use Definition from Dependency\n\n")
-	for pkg, useddefs := range dependencyDefMap {
+	for _, pkg := range slices.Sorted(maps.Keys(dependencyDefMap)) {
+		useddefs := dependencyDefMap[pkg]
+		sort.SliceStable(useddefs, func(i, j int) bool {
+			return strings.Join(useddefs[i].DefKeyword, " ") < strings.Join(useddefs[j].DefKeyword, " ")
+		})
 		buf.WriteString(fmt.Sprintf("- Use pkg %s\n", pkg))
 		size := len(useddefs)
 		for i, usedef := range useddefs {
@@ -304,8 +314,8 @@ func (mgr *CallGraphContextMgr) GetToolDef() []model.ToolDef {
 		return res, nil
 	}
 	res := []model.ToolDef{
-		{FunctionDefinition: findDefUsed, Handler: findDefHandler},
-		{FunctionDefinition: findReference, Handler: findRefHandler},
+		{FunctionDefinition: findDefUsed, Handler: findDefHandler, ReadOnly: true},
+		{FunctionDefinition: findReference, Handler: findRefHandler, ReadOnly: true},
 	}
 	return res
 }
@@ -2,10 +2,23 @@ package context
 
 import (
 	"bytes"
+	"encoding/json"
 	"llm_dev/model"
 )
 
 type ContextMgr interface {
 	WriteContext(buf *bytes.Buffer)
 	GetToolDef() []model.ToolDef
+	// SaveState returns the state of the manager saved with the session, nil if it has no state.
+	SaveState() (json.RawMessage, error)
+	// RestoreState restores the state returned by SaveState when the session is resumed.
+	RestoreState(state json.RawMessage) error
+}
+
+// StableContextMgr is a context manager with content which does not change during a task, e.g. the
+// instructions of its tools or the codebase overview. The stable context is rendered into the request
+// prefix which is cached by the model provider, WriteContext only renders the volatile context.
+type StableContextMgr interface {
+	ContextMgr
+	WriteStableContext(buf *bytes.Buffer)
 }
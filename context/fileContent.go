@@ -7,9 +7,15 @@ import (
 	"llm_dev/codebase/impl"
 	"llm_dev/model"
 	"llm_dev/utils"
+	"maps"
+	"math"
+	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 )
@@ -82,11 +88,137 @@ use this tool to load context of definiton, you should specify two parameters:
 	},
 }
 
+const rawFilePageSize = 200
+
+var readFileTool = openai.FunctionDefinition{
+	Name:   "read_file",
+	Strict: true,
+	Description: `
+Read the raw content of any text file in the codebase with line numbers, e.g. README.md, config.yaml, schema.sql, Dockerfile.
+Large files are read page by page, each page has at most ` + fmt.Sprint(rawFilePageSize) + ` lines, the result tells the line to continue reading from.
+For example 'read_file file = README.md, start_line = 1' reads the first page of README.md.
+For go source code file, prefer 'load_file_context' and 'load_definition_context' tools.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"file": {
+				Type:        jsonschema.String,
+				Description: "the file path to read, e.g. docs/README.md",
+			},
+			"start_line": {
+				Type:        jsonschema.Integer,
+				Description: "the line number to start reading from, starting from 1",
+			},
+		},
+		Required: []string{"file", "start_line"},
+	},
+}
+
+const maxLoadLines = 500
+
+var loadLinesTool = openai.FunctionDefinition{
+	Name:   "load_lines",
+	Strict: true,
+	Description: `
+Load a range of lines of a given file into the loaded file context.
+Use this tool for code which is not inside any definition, e.g. init blocks, large variable tables, embedded SQL,
+or when 'load_definition_context' can not tell which definition you mean.
+For example 'load_lines file = src/foo.go, start_line = 10, end_line = 40' loads line 10 to line 40 of src/foo.go.
+The loaded lines are merged with the other loaded content of the same file.
+At most ` + fmt.Sprint(maxLoadLines) + ` lines are loaded at one time.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"file": {
+				Type:        jsonschema.String,
+				Description: "the file path to load, e.g. src/foo.go",
+			},
+			"start_line": {
+				Type:        jsonschema.Integer,
+				Description: "the first line to load, starting from 1",
+			},
+			"end_line": {
+				Type:        jsonschema.Integer,
+				Description: "the last line to load, inclusive",
+			},
+		},
+		Required: []string{"file", "start_line", "end_line"},
+	},
+}
+
+var unloadFileTool = openai.FunctionDefinition{
+	Name:   "unload_file_context",
+	Strict: true,
+	Description: `
+Unload all the loaded context of the given files, including the definitions, the definition implementations and the line ranges.
+Use this tool to drop the context which is not relevant to the task anymore, to keep the loaded file context small and focused.
+For example 'unload_file_context file = ["src/foo.go"]' removes src/foo.go from the loaded file context.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"file": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type: jsonschema.String,
+				},
+				Description: `the file path array to unload, e.g. ["src/foo.go", "src/test/bar.go"]`,
+			},
+		},
+		Required: []string{"file"},
+	},
+}
+
+var unloadDefsTool = openai.FunctionDefinition{
+	Name:   "unload_definition_context",
+	Strict: true,
+	Description: `
+Unload the loaded implementation of some definitions in a given file, the other loaded context of the file is kept.
+Use this tool to drop the definitions which are not relevant to the task anymore.
+For example 'unload_definition_context file = src/foo.go, defsName = ["GetFileContent"]' removes the implementation of GetFileContent.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"file": {
+				Type:        jsonschema.String,
+				Description: "the file path of the definitions, e.g. src/foo.go",
+			},
+			"defsName": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type: jsonschema.String,
+				},
+				Description: `an array of the definition names to unload, e.g. ["baseUrl", "File", "GetFileContent"]`,
+			},
+		},
+		Required: []string{"file", "defsName"},
+	},
+}
+
+type removedCtx struct {
+	turn uint64
+	desc string
+}
+
 type FileContentCtxMgr struct {
 	rootPath           string
 	BuildCodeBaseCtxop *impl.BuildCodeBaseCtxOps
+	// MaxAge is the number of turns loaded content is kept without being loaded again, 0 keeps it forever.
+	MaxAge uint
 
 	autoLoadCtx map[string]*CodeFile
+	// clock increases on every load, it orders the loaded content from least to most recently used.
+	clock uint64
+	// turnClock records the clock at the end of every turn.
+	turnClock []uint64
+	removed   []removedCtx
 }
 
 func NewFileCtxMgr(root string, buildOp *impl.BuildCodeBaseCtxOps) FileContentCtxMgr {
@@ -100,8 +232,10 @@ func NewFileCtxMgr(root string, buildOp *impl.BuildCodeBaseCtxOps) FileContentCt
 
 func (mgr *FileContentCtxMgr) writeAutoLoadCtx(buf *bytes.Buffer) {
 	description := `
-This section shows all the previous loaded context using tools "load_definition_context" and "load_file_context".
-If you need some relevant context, use tools "load_definition_context" and "load_file_context" to load.
+This section shows all the previous loaded context using tools "load_definition_context", "load_file_context" and "load_lines".
+For go source code file, it shows the loaded definitions and line ranges, for other files, it shows the loaded line ranges.
+If you need some relevant context, use tools "load_definition_context", "load_file_context" and "load_lines" to load.
+If some loaded context is not relevant anymore, use tools "unload_file_context" and "unload_definition_context" to unload.
 You should:
 - Examine the user's request and available codebase context information
 - Determine what context is truly relevant for the task.
@@ -111,18 +245,77 @@ You should:
 `
 	buf.WriteString("## CODEBASE LOADED FILE CONTEXT ##\n\n")
 	buf.WriteString(description)
+	mgr.refresh()
 	buf.WriteString("```\n")
-	for path, codefile := range mgr.autoLoadCtx {
+	for _, path := range slices.Sorted(maps.Keys(mgr.autoLoadCtx)) {
+		codefile := mgr.autoLoadCtx[path]
 		fc := codefile.getContent()
 		buf.WriteString(fmt.Sprintf("# %s\n\n", path))
+		codefile.writeNotes(buf)
 		fc.WriteContent(buf, filepath.Join(mgr.rootPath, path))
 	}
 	buf.WriteString("```\n")
+	mgr.writeRemoved(buf)
 	buf.WriteString("## END OF CODEBASE LOADED FILE CONTEXT ##\n\n")
 }
 
+// writeRemoved lists the context removed during the last turn, so the model knows to load it again if needed.
+func (mgr *FileContentCtxMgr) writeRemoved(buf *bytes.Buffer) {
+	turn := uint64(len(mgr.turnClock))
+	removed := []string{}
+	for _, elem := range mgr.removed {
+		if elem.turn+1 >= turn {
+			removed = append(removed, elem.desc)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	buf.WriteString("\nThe following context was removed from the loaded file context recently, load it again if you still need it:\n")
+	for _, desc := range removed {
+		buf.WriteString(fmt.Sprintf("- %s\n", desc))
+	}
+	buf.WriteByte('\n')
+}
+
+// refresh re-resolves the loaded content of the files changed since the last refresh.
+func (mgr *FileContentCtxMgr) refresh() {
+	for _, path := range slices.Sorted(maps.Keys(mgr.autoLoadCtx)) {
+		file := mgr.autoLoadCtx[path]
+		info, err := os.Stat(filepath.Join(mgr.rootPath, path))
+		if err != nil {
+			delete(mgr.autoLoadCtx, path)
+			mgr.addRemoved(fmt.Sprintf("%s, the file does not exist anymore", path))
+			continue
+		}
+		if !file.changed && info.ModTime().Equal(file.modTime) {
+			// the note of the last change is shown once
+			file.rangeNote = ""
+			continue
+		}
+		for _, desc := range file.refresh(mgr.BuildCodeBaseCtxop, mgr.rootPath) {
+			mgr.addRemoved(desc)
+		}
+		file.modTime = info.ModTime()
+		file.changed = false
+		if file.empty() {
+			delete(mgr.autoLoadCtx, path)
+		}
+	}
+}
+
+func (mgr *FileContentCtxMgr) addRemoved(desc string) {
+	mgr.removed = append(mgr.removed, removedCtx{
+		turn: uint64(len(mgr.turnClock)),
+		desc: desc,
+	})
+}
+
 func (mgr *FileContentCtxMgr) WriteContext(buf *bytes.Buffer) {
 	mgr.writeAutoLoadCtx(buf)
+}
+
+func (mgr *FileContentCtxMgr) WriteStableContext(buf *bytes.Buffer) {
 	buf.WriteString(`
 ### Identify the relevant context ###
 
@@ -145,17 +338,58 @@ func (mgr *FileContentCtxMgr) GetToolDef() []model.ToolDef {
 		if err != nil {
 			return "", err
 		}
+		if err := mgr.checkPaths(args.File...); err != nil {
+			return "", err
+		}
 		res := "IMPORTANT: This only load the definitions in file, the implementations of definition is omitted. You can use 'load_definition_context' tool to load it.\n"
 		for _, v := range args.File {
 			err := mgr.loadFile(v)
 			if err != nil {
 				res += fmt.Sprintf("load file context for %s failed, error: %v\n", v, err)
+			} else if !isSourceFile(v) {
+				res += fmt.Sprintf("load file context for %s success, the file has no definitions, only the first %d lines are loaded, use 'read_file' tool to read the rest\n", v, rawFilePageSize)
 			} else {
 				res += fmt.Sprintf("load file context for %s success\n", v)
 			}
 		}
 		return res, nil
 	}
+	readFileHandler := func(argsStr string) (string, error) {
+		args := struct {
+			File      string
+			StartLine uint `json:"start_line"`
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		if err := mgr.checkPaths(args.File); err != nil {
+			return "", err
+		}
+		return mgr.readFile(args.File, args.StartLine), nil
+	}
+	loadLinesHandler := func(argsStr string) (string, error) {
+		args := struct {
+			File      string
+			StartLine uint `json:"start_line"`
+			EndLine   uint `json:"end_line"`
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		if err := mgr.checkPaths(args.File); err != nil {
+			return "", err
+		}
+		if args.EndLine >= args.StartLine+maxLoadLines {
+			return fmt.Sprintf("load file %s lines %d-%d failed, at most %d lines can be loaded at one time\n", args.File, args.StartLine, args.EndLine, maxLoadLines), nil
+		}
+		err = mgr.loadLines(args.File, args.StartLine, args.EndLine)
+		if err != nil {
+			return fmt.Sprintf("load file %s lines %d-%d failed, error: %v\n", args.File, args.StartLine, args.EndLine, err), nil
+		}
+		return fmt.Sprintf("load file %s lines %d-%d success\n", args.File, args.StartLine, args.EndLine), nil
+	}
 	loadDefsHandler := func(argsStr string) (string, error) {
 		args := struct {
 			File     string
@@ -165,6 +399,9 @@ func (mgr *FileContentCtxMgr) GetToolDef() []model.ToolDef {
 		if err != nil {
 			return "", err
 		}
+		if err := mgr.checkPaths(args.File); err != nil {
+			return "", err
+		}
 		res := ""
 		for _, name := range args.DefsName {
 			err := mgr.loadDefs(args.File, name)
@@ -176,9 +413,52 @@ func (mgr *FileContentCtxMgr) GetToolDef() []model.ToolDef {
 		}
 		return res, nil
 	}
+	unloadFileHandler := func(argsStr string) (string, error) {
+		args := struct {
+			File []string
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		res := ""
+		for _, v := range args.File {
+			err := mgr.unloadFile(v)
+			if err != nil {
+				res += fmt.Sprintf("unload file context for %s failed, error: %v\n", v, err)
+			} else {
+				res += fmt.Sprintf("unload file context for %s success\n", v)
+			}
+		}
+		return res, nil
+	}
+	unloadDefsHandler := func(argsStr string) (string, error) {
+		args := struct {
+			File     string
+			DefsName []string
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		res := ""
+		for _, name := range args.DefsName {
+			err := mgr.unloadDefs(args.File, name)
+			if err != nil {
+				res += fmt.Sprintf("unload file %s %s definition failed, error: %v\n", args.File, name, err)
+			} else {
+				res += fmt.Sprintf("unload file %s %s definition success\n", args.File, name)
+			}
+		}
+		return res, nil
+	}
 	res := []model.ToolDef{
 		{FunctionDefinition: loadFileTool, Handler: loadFileHandler},
 		{FunctionDefinition: loadFileDefsTool, Handler: loadDefsHandler},
+		{FunctionDefinition: readFileTool, Handler: readFileHandler, ReadOnly: true},
+		{FunctionDefinition: loadLinesTool, Handler: loadLinesHandler},
+		{FunctionDefinition: unloadFileTool, Handler: unloadFileHandler},
+		{FunctionDefinition: unloadDefsTool, Handler: unloadDefsHandler},
 	}
 	return res
 }
@@ -189,7 +469,90 @@ func (mgr *FileContentCtxMgr) loadFile(relPath string) error {
 		mgr.autoLoadCtx[relPath] = &codeFile
 	}
 	codeFile := mgr.autoLoadCtx[relPath]
-	return codeFile.loadAllDefs(mgr.BuildCodeBaseCtxop)
+	if !isSourceFile(relPath) {
+		return codeFile.loadRange(utils.Range{StartLine: 1, EndLine: rawFilePageSize + 1}, mgr.rootPath, mgr.tick())
+	}
+	return codeFile.loadAllDefs(mgr.BuildCodeBaseCtxop, mgr.tick())
+}
+
+func (mgr *FileContentCtxMgr) tick() uint64 {
+	mgr.clock++
+	return mgr.clock
+}
+
+func (mgr *FileContentCtxMgr) loadLines(relPath string, startLine uint, endLine uint) error {
+	codeFile := mgr.autoLoadCtx[relPath]
+	if codeFile == nil {
+		newFile := NewCodeFile(relPath)
+		codeFile = &newFile
+	}
+	err := codeFile.loadRange(utils.Range{StartLine: startLine, EndLine: endLine + 1}, mgr.rootPath, mgr.tick())
+	if err != nil {
+		return err
+	}
+	mgr.autoLoadCtx[relPath] = codeFile
+	return nil
+}
+
+// checkPaths rejects the file paths leaving the codebase, the tools must not read outside of it.
+func (mgr *FileContentCtxMgr) checkPaths(relPaths ...string) error {
+	for _, relPath := range relPaths {
+		if _, err := resolvePath(mgr.rootPath, relPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePath joins the path relative to the codebase root, a path leaving the root, with .. or through
+// a symlink, is an error. A missing file is not an error here, reading it reports it.
+func resolvePath(root string, relPath string) (string, error) {
+	clean := filepath.Clean(relPath)
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("path %s is outside the codebase, use a path relative to the codebase root", relPath)
+	}
+	path := filepath.Join(root, clean)
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path, nil
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return path, nil
+	}
+	if rel, err := filepath.Rel(resolvedRoot, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("path %s resolves outside the codebase", relPath)
+	}
+	return path, nil
+}
+
+// readFile renders one page of the raw file content starting from startLine.
+func (mgr *FileContentCtxMgr) readFile(relPath string, startLine uint) string {
+	path, err := resolvePath(mgr.rootPath, relPath)
+	if err != nil {
+		return fmt.Sprintf("read file %s failed, error: %v\n", relPath, err)
+	}
+	total, err := utils.TextLineCount(path)
+	if err != nil {
+		return fmt.Sprintf("read file %s failed, error: %v\n", relPath, err)
+	}
+	if total == 0 {
+		return fmt.Sprintf("file %s is empty\n", relPath)
+	}
+	startLine = max(startLine, 1)
+	if startLine > total {
+		return fmt.Sprintf("read file %s failed, start line %d exceeds the file length %d\n", relPath, startLine, total)
+	}
+	endLine := min(startLine+rawFilePageSize, total+1)
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("# %s (lines %d-%d of %d)\n\n", relPath, startLine, endLine-1, total))
+	fc := utils.FileContent{}
+	fc.AddChunk(utils.Range{StartLine: startLine, EndLine: endLine})
+	fc.WriteContent(&buf, path)
+	if endLine <= total {
+		buf.WriteString(fmt.Sprintf("\n... %d more lines, use 'read_file' tool with start_line = %d to continue reading\n", total-endLine+1, endLine))
+	}
+	return buf.String()
 }
 func (mgr *FileContentCtxMgr) loadDefs(relPath string, identifier string) error {
 	if mgr.autoLoadCtx[relPath] == nil {
@@ -197,15 +560,142 @@ func (mgr *FileContentCtxMgr) loadDefs(relPath string, identifier string) error
 		mgr.autoLoadCtx[relPath] = &codeFile
 	}
 	codeFile := mgr.autoLoadCtx[relPath]
-	return codeFile.loadDefs(identifier, mgr.BuildCodeBaseCtxop)
+	return codeFile.loadDefs(identifier, mgr.BuildCodeBaseCtxop, mgr.tick())
+}
+
+func (mgr *FileContentCtxMgr) unloadFile(relPath string) error {
+	if mgr.autoLoadCtx[relPath] == nil {
+		return fmt.Errorf("file %s is not loaded", relPath)
+	}
+	delete(mgr.autoLoadCtx, relPath)
+	mgr.addRemoved(fmt.Sprintf("%s, unloaded", relPath))
+	return nil
+}
+
+func (mgr *FileContentCtxMgr) unloadDefs(relPath string, identifier string) error {
+	codeFile := mgr.autoLoadCtx[relPath]
+	if codeFile == nil {
+		return fmt.Errorf("file %s is not loaded", relPath)
+	}
+	if !codeFile.unloadDefs(identifier) {
+		return fmt.Errorf("file %s %s definition is not loaded", relPath, identifier)
+	}
+	if codeFile.empty() {
+		delete(mgr.autoLoadCtx, relPath)
+	}
+	mgr.addRemoved(fmt.Sprintf("%s %s definition, unloaded", relPath, identifier))
+	return nil
+}
+
+// AgeContext ends the current turn and drops the content which is not loaded again within the last MaxAge turns.
+func (mgr *FileContentCtxMgr) AgeContext() {
+	mgr.turnClock = append(mgr.turnClock, mgr.clock)
+	turn := uint64(len(mgr.turnClock))
+	removed := []removedCtx{}
+	for _, elem := range mgr.removed {
+		if elem.turn+1 >= turn {
+			removed = append(removed, elem)
+		}
+	}
+	mgr.removed = removed
+	if mgr.MaxAge == 0 || len(mgr.turnClock) <= int(mgr.MaxAge) {
+		return
+	}
+	threshold := mgr.turnClock[len(mgr.turnClock)-1-int(mgr.MaxAge)]
+	for _, path := range slices.Sorted(maps.Keys(mgr.autoLoadCtx)) {
+		file := mgr.autoLoadCtx[path]
+		for {
+			lastUsed, ok := file.oldest()
+			if !ok || lastUsed > threshold {
+				break
+			}
+			for _, desc := range file.evict(lastUsed) {
+				mgr.addRemoved(fmt.Sprintf("%s, not used for %d turns", desc, mgr.MaxAge))
+			}
+		}
+		if file.empty() {
+			delete(mgr.autoLoadCtx, path)
+		}
+	}
+}
+
+func (mgr *FileContentCtxMgr) OnTurnEnd() {
+	mgr.AgeContext()
+}
+
+// OnFileChanged marks the loaded files as changed, their content is re-resolved at the next render.
+func (mgr *FileContentCtxMgr) OnFileChanged(paths []string) {
+	for _, path := range paths {
+		if file, exist := mgr.autoLoadCtx[filepath.Clean(path)]; exist {
+			file.changed = true
+		}
+	}
+}
+
+func (mgr *FileContentCtxMgr) Priority() int {
+	return 0
+}
+
+// Evict drops the least recently loaded definition, line range or file definition summary,
+// the file is removed from the loaded context once nothing of it is left.
+func (mgr *FileContentCtxMgr) Evict() bool {
+	var oldestFile *CodeFile
+	oldest := uint64(math.MaxUint64)
+	for _, file := range mgr.autoLoadCtx {
+		lastUsed, ok := file.oldest()
+		if !ok {
+			continue
+		}
+		if lastUsed < oldest || lastUsed == oldest && file.path < oldestFile.path {
+			oldest = lastUsed
+			oldestFile = file
+		}
+	}
+	if oldestFile == nil {
+		return false
+	}
+	for _, desc := range oldestFile.evict(oldest) {
+		mgr.addRemoved(fmt.Sprintf("%s, evicted to fit the context token budget", desc))
+	}
+	if oldestFile.empty() {
+		delete(mgr.autoLoadCtx, oldestFile.path)
+	}
+	log.Info().Str("file", oldestFile.path).Msg("evict loaded file context")
+	return true
+}
+
+func isSourceFile(path string) bool {
+	return filepath.Ext(path) == ".go"
+}
+
+type loadedDef struct {
+	impl.Definition
+	lastUsed uint64
+	// text is the content of the definition when it is resolved against the file last time.
+	text string
+	// note tells the model how the definition changed since it was loaded.
+	note string
+}
+
+type loadedRange struct {
+	utils.Range
+	lastUsed uint64
 }
 
 type CodeFile struct {
-	path       string
-	ext        string
-	defs       []impl.Definition
-	loadedDefs []impl.Definition
-	usedType   []impl.TypeInfo
+	path        string
+	ext         string
+	defs        []impl.Definition
+	summaryUsed uint64
+	loadedDefs  []loadedDef
+	ranges      []loadedRange
+	usedType    []impl.TypeInfo
+	// modTime is the file modification time when the loaded content is resolved last time.
+	modTime   time.Time
+	rangeNote string
+	// changed is set when a tool reports the file is changed, it is refreshed at the next render
+	// even if the modification time looks the same.
+	changed bool
 }
 
 func NewCodeFile(path string) CodeFile {
@@ -222,11 +712,206 @@ func (file *CodeFile) getContent() utils.FileContent {
 	for _, def := range file.loadedDefs {
 		fc.AddChunk(def.Content)
 	}
+	for _, r := range file.ranges {
+		fc.AddChunk(r.Range)
+	}
 	return fc
 }
 
-func (file *CodeFile) loadAllDefs(op *impl.BuildCodeBaseCtxOps) error {
+func (file *CodeFile) writeNotes(buf *bytes.Buffer) {
+	if file.rangeNote != "" {
+		buf.WriteString(fmt.Sprintf("NOTE: %s\n", file.rangeNote))
+	}
+	for _, def := range file.loadedDefs {
+		if def.note != "" {
+			buf.WriteString(fmt.Sprintf("NOTE: %s\n", def.note))
+		}
+	}
+}
+
+// refresh re-resolves the loaded definitions against the current file by identifier and keyword,
+// it updates the line ranges of the moved and changed definitions and drops the disappeared ones.
+// It returns the description of the dropped content.
+func (file *CodeFile) refresh(op *impl.BuildCodeBaseCtxOps, root string) []string {
+	removed := []string{}
+	path := filepath.Join(root, file.path)
+	file.rangeNote = ""
+	if len(file.ranges) != 0 {
+		total, err := utils.TextLineCount(path)
+		if err == nil {
+			ranges := []loadedRange{}
+			for _, r := range file.ranges {
+				if r.StartLine > total {
+					removed = append(removed, fmt.Sprintf("%s lines %d-%d, the file has only %d lines now", file.path, r.StartLine, r.EndLine-1, total))
+					continue
+				}
+				r.EndLine = min(r.EndLine, total+1)
+				ranges = append(ranges, r)
+			}
+			file.ranges = ranges
+		}
+		if !file.modTime.IsZero() {
+			file.rangeNote = "the file changed since the line ranges were loaded, the lines may show different code now"
+		}
+	}
+	if !isSourceFile(file.path) || len(file.defs) == 0 && len(file.loadedDefs) == 0 {
+		return removed
+	}
+	current, err := op.ParseFileDefs(file.path)
+	if err != nil {
+		log.Error().Err(err).Str("file", file.path).Msg("parse file definitions fail")
+		return removed
+	}
+	if file.defs != nil {
+		file.defs = current
+	}
+	defs := []loadedDef{}
+	for _, def := range file.loadedDefs {
+		match := matchDef(def.Definition, current)
+		if match == nil {
+			removed = append(removed, fmt.Sprintf("%s %s definition, it does not exist in the file anymore", file.path, def.Identifier))
+			continue
+		}
+		text, err := utils.ReadRange(path, match.Content)
+		if err != nil {
+			log.Error().Err(err).Str("file", file.path).Msg("read definition content fail")
+		}
+		switch {
+		case def.text == "":
+		case text != def.text:
+			def.note = fmt.Sprintf("definition %s changed since it was loaded, the current content is shown", def.Identifier)
+		case match.Content.StartLine != def.Content.StartLine:
+			def.note = fmt.Sprintf("definition %s moved from line %d to line %d", def.Identifier, def.Content.StartLine, match.Content.StartLine)
+		default:
+			def.note = ""
+		}
+		def.Content = match.Content
+		def.Summary = match.Summary
+		def.text = text
+		defs = append(defs, def)
+	}
+	file.loadedDefs = uniqueDefs(defs)
+	return removed
+}
+
+// matchDef finds the definition with the same identifier and keyword in defs,
+// the one closest to the original line is chosen if there are more than one.
+func matchDef(def impl.Definition, defs []impl.Definition) *impl.Definition {
+	var res *impl.Definition
+	distance := func(d impl.Definition) uint {
+		if d.Content.StartLine > def.Content.StartLine {
+			return d.Content.StartLine - def.Content.StartLine
+		}
+		return def.Content.StartLine - d.Content.StartLine
+	}
+	for i, elem := range defs {
+		if elem.Identifier != def.Identifier || !slices.Equal(elem.Keyword, def.Keyword) {
+			continue
+		}
+		if res == nil || distance(elem) < distance(*res) {
+			res = &defs[i]
+		}
+	}
+	return res
+}
+
+func (file *CodeFile) empty() bool {
+	return len(file.defs) == 0 && len(file.loadedDefs) == 0 && len(file.ranges) == 0
+}
+
+// oldest returns the last used clock of the least recently used content in the file.
+func (file *CodeFile) oldest() (uint64, bool) {
+	res := uint64(math.MaxUint64)
+	found := false
+	if len(file.defs) != 0 {
+		res = file.summaryUsed
+		found = true
+	}
+	for _, def := range file.loadedDefs {
+		if def.lastUsed < res {
+			res = def.lastUsed
+			found = true
+		}
+	}
+	for _, r := range file.ranges {
+		if r.lastUsed < res {
+			res = r.lastUsed
+			found = true
+		}
+	}
+	return res, found
+}
+
+// evict drops all the content last used at the clock lastUsed and returns the description of the dropped content.
+func (file *CodeFile) evict(lastUsed uint64) []string {
+	res := []string{}
+	if len(file.defs) != 0 && file.summaryUsed == lastUsed {
+		file.defs = nil
+		res = append(res, fmt.Sprintf("%s definitions", file.path))
+	}
+	defs := []loadedDef{}
+	for _, def := range file.loadedDefs {
+		if def.lastUsed != lastUsed {
+			defs = append(defs, def)
+		} else {
+			res = append(res, fmt.Sprintf("%s %s definition", file.path, def.Identifier))
+		}
+	}
+	file.loadedDefs = defs
+	ranges := []loadedRange{}
+	for _, r := range file.ranges {
+		if r.lastUsed != lastUsed {
+			ranges = append(ranges, r)
+		} else {
+			res = append(res, fmt.Sprintf("%s lines %d-%d", file.path, r.StartLine, r.EndLine-1))
+		}
+	}
+	file.ranges = ranges
+	return res
+}
+
+// unloadDefs drops the loaded definitions named identifier, it returns false if none is loaded.
+func (file *CodeFile) unloadDefs(identifier string) bool {
+	defs := []loadedDef{}
+	for _, def := range file.loadedDefs {
+		if def.Identifier != identifier {
+			defs = append(defs, def)
+		}
+	}
+	found := len(defs) != len(file.loadedDefs)
+	file.loadedDefs = defs
+	return found
+}
+
+// loadRange loads a plain line range of the file, the range is clamped to the file length.
+func (file *CodeFile) loadRange(r utils.Range, root string, tick uint64) error {
+	path, err := resolvePath(root, file.path)
+	if err != nil {
+		return err
+	}
+	total, err := utils.TextLineCount(path)
+	if err != nil {
+		return err
+	}
+	if r.StartLine == 0 || r.StartLine > total || r.EndLine <= r.StartLine {
+		return fmt.Errorf("file %s range %d-%d is invalid, the file has %d lines", file.path, r.StartLine, r.EndLine-1, total)
+	}
+	r.EndLine = min(r.EndLine, total+1)
+	// the lines are loaded again, they show the current code
+	file.rangeNote = ""
+	for i := range file.ranges {
+		if file.ranges[i].Range == r {
+			file.ranges[i].lastUsed = tick
+			return nil
+		}
+	}
+	file.ranges = append(file.ranges, loadedRange{Range: r, lastUsed: tick})
+	return nil
+}
+
+func (file *CodeFile) loadAllDefs(op *impl.BuildCodeBaseCtxOps, tick uint64) error {
 	if file.defs != nil {
+		file.summaryUsed = tick
 		return nil
 	}
 	filter := impl.GenDefFilter(&file.path, nil, nil)
@@ -235,23 +920,37 @@ func (file *CodeFile) loadAllDefs(op *impl.BuildCodeBaseCtxOps) error {
 		return fmt.Errorf("file %s definition empty", file.path)
 	}
 	file.defs = res
+	file.summaryUsed = tick
+	file.modTime = time.Time{}
 	return nil
 }
-func (file *CodeFile) loadDefs(identifier string, op *impl.BuildCodeBaseCtxOps) error {
+func (file *CodeFile) loadDefs(identifier string, op *impl.BuildCodeBaseCtxOps, tick uint64) error {
 	filter := impl.GenDefFilter(&file.path, &identifier, nil)
 	res := op.FindDefs(filter)
 	if len(res) == 0 {
 		return fmt.Errorf("file %s %s definition not found", file.path, identifier)
 	}
-	file.loadedDefs = addDefs(file.loadedDefs, res)
+	file.loadedDefs = addDefs(file.loadedDefs, res, tick)
+	file.modTime = time.Time{}
 	return nil
 }
-func addDefs(defs []impl.Definition, new []impl.Definition) []impl.Definition {
-	res := append(defs, new...)
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Content.StartLine < res[j].Content.StartLine
+func addDefs(defs []loadedDef, new []impl.Definition, tick uint64) []loadedDef {
+	res := defs
+	for _, def := range new {
+		res = append(res, loadedDef{Definition: def, lastUsed: tick})
+	}
+	return uniqueDefs(res)
+}
+
+// uniqueDefs sorts the definitions by line and drops the duplicated ones, the most recently used one is kept.
+func uniqueDefs(res []loadedDef) []loadedDef {
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Content.StartLine != res[j].Content.StartLine {
+			return res[i].Content.StartLine < res[j].Content.StartLine
+		}
+		return res[i].lastUsed > res[j].lastUsed
 	})
-	unique := []impl.Definition{}
+	unique := []loadedDef{}
 	resLen := len(res)
 	if resLen != 0 {
 		unique = append(unique, res[0])
@@ -1,8 +1,17 @@
 package context
 
 import (
+	"bytes"
+	"fmt"
+	"llm_dev/codebase/impl"
 	"llm_dev/database"
+	"llm_dev/model"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFileContentCtxMgr_WriteExternalDefs(t *testing.T) {
@@ -15,3 +24,427 @@ func TestFileContentCtxMgr_WriteExternalDefs(t *testing.T) {
 		// }
 	})
 }
+
+func TestFileContentCtxMgr_readFile(t *testing.T) {
+	root := t.TempDir()
+	var content strings.Builder
+	for i := 1; i <= rawFilePageSize+10; i++ {
+		content.WriteString(fmt.Sprintf("line %d\n", i))
+	}
+	writeTestFiles(t, root, map[string]string{
+		"README.md":  "# title\nhello\n",
+		"big.txt":    content.String(),
+		"empty.yaml": "",
+	})
+	mgr := NewFileCtxMgr(root, nil)
+	tests := []struct {
+		name      string
+		file      string
+		startLine uint
+		contains  []string
+	}{
+		{name: "small file", file: "README.md", startLine: 1, contains: []string{"(lines 1-2 of 2)", "  1| # title", "  2| hello"}},
+		{name: "first page", file: "big.txt", startLine: 1, contains: []string{"(lines 1-200 of 210)", "200| line 200", "start_line = 201"}},
+		{name: "last page", file: "big.txt", startLine: 201, contains: []string{"(lines 201-210 of 210)", "210| line 210"}},
+		{name: "out of range", file: "big.txt", startLine: 300, contains: []string{"exceeds the file length 210"}},
+		{name: "empty file", file: "empty.yaml", startLine: 1, contains: []string{"is empty"}},
+		{name: "missing file", file: "missing.txt", startLine: 1, contains: []string{"read file missing.txt failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mgr.readFile(tt.file, tt.startLine)
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("readFile() = %q, should contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileContentCtxMgr_pathOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "code")
+	writeTestFiles(t, dir, map[string]string{
+		"secret.txt":   "password\n",
+		"code/main.go": "package main\n",
+	})
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewFileCtxMgr(root, nil)
+	handlers := map[string]model.ToolHandler{}
+	for _, def := range mgr.GetToolDef() {
+		handlers[def.Name] = def.Handler
+	}
+	calls := []struct {
+		tool string
+		args string
+	}{
+		{"read_file", `{"file":"../secret.txt","start_line":1}`},
+		{"read_file", `{"file":"code/../../secret.txt","start_line":1}`},
+		{"read_file", `{"file":"` + filepath.Join(dir, "secret.txt") + `","start_line":1}`},
+		{"read_file", `{"file":"link.txt","start_line":1}`},
+		{"load_lines", `{"file":"../secret.txt","start_line":1,"end_line":1}`},
+		{"load_file_context", `{"file":["main.go","../secret.txt"]}`},
+		{"load_definition_context", `{"file":"../secret.txt","defs_name":["x"]}`},
+	}
+	for _, call := range calls {
+		res, err := handlers[call.tool](call.args)
+		if err == nil || !strings.Contains(err.Error(), "outside the codebase") {
+			t.Errorf("%s %s = %q, %v, want an outside the codebase error", call.tool, call.args, res, err)
+		}
+	}
+	if len(mgr.autoLoadCtx) != 0 {
+		t.Errorf("a rejected call loaded context: %v", mgr.autoLoadCtx)
+	}
+	if got := mgr.readFile("../secret.txt", 1); strings.Contains(got, "password") {
+		t.Errorf("readFile read outside the codebase: %s", got)
+	}
+	if res, err := handlers["read_file"](`{"file":"./main.go","start_line":1}`); err != nil || !strings.Contains(res, "package main") {
+		t.Errorf("read_file main.go = %q, %v", res, err)
+	}
+}
+
+func TestFileContentCtxMgr_loadRawFile(t *testing.T) {
+	t.Run("load non go file as plain range", func(t *testing.T) {
+		root := t.TempDir()
+		writeTestFiles(t, root, map[string]string{
+			"docker/Dockerfile": "FROM golang\nRUN go build\n",
+		})
+		mgr := NewFileCtxMgr(root, nil)
+		if err := mgr.loadFile("docker/Dockerfile"); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		for _, want := range []string{"# docker/Dockerfile", "  1| FROM golang", "  2| RUN go build"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("loaded context should contain %q:\n%s", want, buf.String())
+			}
+		}
+	})
+}
+
+func TestFileContentCtxMgr_loadLines(t *testing.T) {
+	root := t.TempDir()
+	var content strings.Builder
+	content.WriteString("package foo\n\n// line 3\n// line 4\nfunc foo() {\n\ta := 1\n\t_ = a\n}\n")
+	for i := 9; i <= 30; i++ {
+		content.WriteString(fmt.Sprintf("// line %d\n", i))
+	}
+	writeTestFiles(t, root, map[string]string{"foo.go": content.String()})
+	op := &impl.BuildCodeBaseCtxOps{RootPath: root}
+
+	t.Run("merge loaded lines with loaded definitions", func(t *testing.T) {
+		mgr := NewFileCtxMgr(root, op)
+		codeFile := NewCodeFile("foo.go")
+		codeFile.loadedDefs = []loadedDef{{Definition: impl.Definition{Identifier: "foo", Keyword: []string{"function", "foo"}, Content: utils.Range{StartLine: 5, EndLine: 9}}}}
+		mgr.autoLoadCtx["foo.go"] = &codeFile
+		if err := mgr.loadLines("foo.go", 7, 12); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.loadLines("foo.go", 20, 21); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		want := "  5| func foo() {\n  6| \ta := 1\n  7| \t_ = a\n  8| }\n  9| // line 9\n 10| // line 10\n 11| // line 11\n 12| // line 12\n...\n 20| // line 20\n 21| // line 21\n"
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("loaded context = %s, want to contain %s", buf.String(), want)
+		}
+	})
+	t.Run("invalid range is not loaded", func(t *testing.T) {
+		mgr := NewFileCtxMgr(root, op)
+		if err := mgr.loadLines("foo.go", 40, 50); err == nil {
+			t.Errorf("loadLines out of range should fail")
+		}
+		if err := mgr.loadLines("missing.go", 1, 2); err == nil {
+			t.Errorf("loadLines of missing file should fail")
+		}
+		if len(mgr.autoLoadCtx) != 0 {
+			t.Errorf("failed load should not add file context")
+		}
+	})
+}
+
+func TestFileContentCtxMgr_Evict(t *testing.T) {
+	t.Run("evict least recently loaded content", func(t *testing.T) {
+		root := t.TempDir()
+		writeTestFiles(t, root, map[string]string{
+			"a.md": "a1\na2\na3\na4\n",
+			"b.md": "b1\nb2\nb3\nb4\n",
+		})
+		mgr := NewFileCtxMgr(root, nil)
+		mgr.loadLines("a.md", 1, 1)
+		mgr.loadLines("b.md", 1, 1)
+		mgr.loadLines("a.md", 3, 3)
+		// loading again refreshes the range
+		mgr.loadLines("a.md", 1, 1)
+
+		if !mgr.Evict() {
+			t.Fatal("Evict should drop content")
+		}
+		if _, exist := mgr.autoLoadCtx["b.md"]; exist {
+			t.Errorf("b.md is least recently used and should be evicted")
+		}
+		if !mgr.Evict() {
+			t.Fatal("Evict should drop content")
+		}
+		ranges := mgr.autoLoadCtx["a.md"].ranges
+		if len(ranges) != 1 || ranges[0].StartLine != 1 {
+			t.Errorf("a.md line 3 should be evicted before line 1, got %v", ranges)
+		}
+		mgr.Evict()
+		if mgr.Evict() || len(mgr.autoLoadCtx) != 0 {
+			t.Errorf("nothing should be left to evict")
+		}
+	})
+}
+
+func TestFileContentCtxMgr_unload(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"a.go": "package a\n\nfunc A() {\n}\n\nfunc B() {\n}\n",
+	})
+	newMgr := func() FileContentCtxMgr {
+		mgr := NewFileCtxMgr(root, &impl.BuildCodeBaseCtxOps{RootPath: root})
+		codeFile := NewCodeFile("a.go")
+		codeFile.loadedDefs = addDefs(nil, []impl.Definition{
+			{Identifier: "A", Keyword: []string{"function", "A"}, Content: utils.Range{StartLine: 3, EndLine: 5}},
+			{Identifier: "B", Keyword: []string{"function", "B"}, Content: utils.Range{StartLine: 6, EndLine: 8}},
+		}, mgr.tick())
+		mgr.autoLoadCtx["a.go"] = &codeFile
+		return mgr
+	}
+	t.Run("unload definition", func(t *testing.T) {
+		mgr := newMgr()
+		if err := mgr.unloadDefs("a.go", "A"); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.unloadDefs("a.go", "A"); err == nil {
+			t.Errorf("unload a definition twice should fail")
+		}
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		if strings.Contains(buf.String(), "  3| func A()") || !strings.Contains(buf.String(), "  6| func B()") {
+			t.Errorf("only definition A should be unloaded:\n%s", buf.String())
+		}
+		if !strings.Contains(buf.String(), "- a.go A definition, unloaded") {
+			t.Errorf("removal should be shown:\n%s", buf.String())
+		}
+		mgr.unloadDefs("a.go", "B")
+		if len(mgr.autoLoadCtx) != 0 {
+			t.Errorf("file with nothing loaded should be removed")
+		}
+	})
+	t.Run("unload file", func(t *testing.T) {
+		mgr := newMgr()
+		if err := mgr.unloadFile("a.go"); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.unloadFile("a.go"); err == nil {
+			t.Errorf("unload a file twice should fail")
+		}
+		if len(mgr.autoLoadCtx) != 0 {
+			t.Errorf("file should be unloaded")
+		}
+	})
+	t.Run("removal is shown for one turn", func(t *testing.T) {
+		mgr := newMgr()
+		mgr.unloadFile("a.go")
+		mgr.AgeContext()
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		if !strings.Contains(buf.String(), "a.go, unloaded") {
+			t.Errorf("removal should be shown in the next turn:\n%s", buf.String())
+		}
+		mgr.AgeContext()
+		buf.Reset()
+		mgr.writeAutoLoadCtx(&buf)
+		if strings.Contains(buf.String(), "a.go, unloaded") {
+			t.Errorf("removal should not be shown after one turn:\n%s", buf.String())
+		}
+	})
+}
+
+func TestFileContentCtxMgr_AgeContext(t *testing.T) {
+	t.Run("drop content not loaded for max age turns", func(t *testing.T) {
+		root := t.TempDir()
+		writeTestFiles(t, root, map[string]string{
+			"a.md": "a1\na2\na3\n",
+			"b.md": "b1\nb2\nb3\n",
+		})
+		mgr := NewFileCtxMgr(root, nil)
+		mgr.MaxAge = 2
+		mgr.loadLines("a.md", 1, 1)
+		mgr.loadLines("b.md", 1, 1)
+		mgr.AgeContext()
+		mgr.loadLines("a.md", 1, 1)
+		mgr.AgeContext()
+		if len(mgr.autoLoadCtx) != 2 {
+			t.Fatalf("content within max age should be kept")
+		}
+		mgr.AgeContext()
+		if _, exist := mgr.autoLoadCtx["b.md"]; exist {
+			t.Errorf("b.md is not loaded for 2 turns and should be dropped")
+		}
+		if _, exist := mgr.autoLoadCtx["a.md"]; !exist {
+			t.Errorf("a.md is loaded again and should be kept")
+		}
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		if !strings.Contains(buf.String(), "- b.md lines 1-1, not used for 2 turns") {
+			t.Errorf("aged content should be shown as removed:\n%s", buf.String())
+		}
+	})
+}
+
+func TestFileContentCtxMgr_refresh(t *testing.T) {
+	src := "package a\n\nfunc A() {\n\treturn\n}\n\nfunc B() {\n}\n\nfunc C() {\n}\n"
+	newMgr := func(t *testing.T) (FileContentCtxMgr, string) {
+		root := t.TempDir()
+		writeTestFiles(t, root, map[string]string{"a.go": src})
+		mgr := NewFileCtxMgr(root, &impl.BuildCodeBaseCtxOps{RootPath: root})
+		codeFile := NewCodeFile("a.go")
+		// the index is stale, definition A is recorded one line off
+		codeFile.loadedDefs = addDefs(nil, []impl.Definition{
+			{Identifier: "A", Keyword: []string{"function", "A"}, Content: utils.Range{StartLine: 4, EndLine: 7}},
+			{Identifier: "B", Keyword: []string{"function", "B"}, Content: utils.Range{StartLine: 7, EndLine: 9}},
+			{Identifier: "C", Keyword: []string{"function", "C"}, Content: utils.Range{StartLine: 10, EndLine: 12}},
+		}, mgr.tick())
+		mgr.autoLoadCtx["a.go"] = &codeFile
+		return mgr, root
+	}
+	render := func(mgr *FileContentCtxMgr) string {
+		var buf bytes.Buffer
+		mgr.writeAutoLoadCtx(&buf)
+		return buf.String()
+	}
+	edit := func(t *testing.T, root string, content string) {
+		path := filepath.Join(root, "a.go")
+		os.WriteFile(path, []byte(content), 0644)
+		// make sure the modification time changes on coarse grained file systems
+		future := time.Now().Add(time.Second)
+		os.Chtimes(path, future, future)
+	}
+
+	t.Run("resolve stale index silently on load", func(t *testing.T) {
+		mgr, _ := newMgr(t)
+		got := render(&mgr)
+		if !strings.Contains(got, "  3| func A() {\n  4| \treturn\n  5| }\n") || strings.Contains(got, "NOTE:") {
+			t.Errorf("definition A should be resolved without note:\n%s", got)
+		}
+	})
+	t.Run("mark moved changed and disappeared definitions", func(t *testing.T) {
+		mgr, root := newMgr(t)
+		render(&mgr)
+		edit(t, root, "package a\n\n// new comment\nfunc A() {\n\treturn\n}\n\nfunc B() {\n\tprintln()\n}\n")
+		got := render(&mgr)
+		for _, want := range []string{
+			"NOTE: definition A moved from line 3 to line 4",
+			"NOTE: definition B changed since it was loaded",
+			"  9| \tprintln()",
+			"- a.go C definition, it does not exist in the file anymore",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("rendered context should contain %q:\n%s", want, got)
+			}
+		}
+	})
+	t.Run("clear the range note after reload", func(t *testing.T) {
+		mgr, root := newMgr(t)
+		if err := mgr.loadLines("a.go", 7, 8); err != nil {
+			t.Fatal(err)
+		}
+		render(&mgr)
+		edit(t, root, src+"\nfunc D() {\n}\n")
+		const note = "NOTE: the file changed since the line ranges were loaded"
+		if got := render(&mgr); !strings.Contains(got, note) {
+			t.Fatalf("the change should be noted:\n%s", got)
+		}
+		if err := mgr.loadLines("a.go", 7, 8); err != nil {
+			t.Fatal(err)
+		}
+		if got := render(&mgr); strings.Contains(got, note) {
+			t.Errorf("the note should be cleared after the lines are loaded again:\n%s", got)
+		}
+		edit(t, root, src+"\nfunc E() {\n}\n")
+		render(&mgr)
+		if got := render(&mgr); strings.Contains(got, note) {
+			t.Errorf("the note should be cleared when the file does not change again:\n%s", got)
+		}
+	})
+	t.Run("drop deleted file", func(t *testing.T) {
+		mgr, root := newMgr(t)
+		render(&mgr)
+		os.Remove(filepath.Join(root, "a.go"))
+		got := render(&mgr)
+		if len(mgr.autoLoadCtx) != 0 || !strings.Contains(got, "- a.go, the file does not exist anymore") {
+			t.Errorf("deleted file should be removed:\n%s", got)
+		}
+	})
+}
+
+func TestFileContentCtxMgr_WriteContext_golden(t *testing.T) {
+	root := filepath.Join("testdata", "src")
+	newMgr := func() FileContentCtxMgr {
+		mgr := NewFileCtxMgr(root, &impl.BuildCodeBaseCtxOps{RootPath: root})
+		for _, file := range []string{"util/util.go", "main.go"} {
+			codeFile := NewCodeFile(file)
+			mgr.autoLoadCtx[file] = &codeFile
+		}
+		mgr.autoLoadCtx["main.go"].loadedDefs = addDefs(nil, []impl.Definition{
+			{Identifier: "main", Keyword: []string{"function", "main"}, Content: utils.Range{StartLine: 7, EndLine: 10}},
+			{Identifier: "greeting", Keyword: []string{"var", "greeting"}, Content: utils.Range{StartLine: 5, EndLine: 6}},
+		}, mgr.tick())
+		mgr.autoLoadCtx["util/util.go"].loadedDefs = addDefs(nil, []impl.Definition{
+			{Identifier: "Inc", Keyword: []string{"method", "Inc", "Counter"}, Content: utils.Range{StartLine: 7, EndLine: 10}},
+		}, mgr.tick())
+		mgr.loadLines("README.md", 1, 3)
+		return mgr
+	}
+	mgr := newMgr()
+	var buf bytes.Buffer
+	mgr.WriteContext(&buf)
+	for i := 0; i < 10; i++ {
+		other := newMgr()
+		var otherBuf bytes.Buffer
+		other.WriteContext(&otherBuf)
+		if otherBuf.String() != buf.String() {
+			t.Fatalf("rendered context is not deterministic")
+		}
+	}
+	checkGolden(t, "file_context", buf.String())
+}
+
+func TestFileContentCtxMgr_OnFileChanged(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.go": "package a\n\nfunc A() {\n}\n"})
+	mgr := NewFileCtxMgr(root, &impl.BuildCodeBaseCtxOps{RootPath: root})
+	codeFile := NewCodeFile("a.go")
+	mgr.autoLoadCtx["a.go"] = &codeFile
+	codeFile.loadedDefs = addDefs(nil, []impl.Definition{
+		{Identifier: "A", Keyword: []string{"function", "A"}, Content: utils.Range{StartLine: 3, EndLine: 5}},
+	}, mgr.tick())
+	var buf bytes.Buffer
+	mgr.WriteContext(&buf)
+	info, err := os.Stat(filepath.Join(root, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the edit keeps the modification time, only the reported change triggers the refresh
+	writeTestFiles(t, root, map[string]string{"a.go": "package a\n\n\nfunc A() {\n}\n"})
+	os.Chtimes(filepath.Join(root, "a.go"), info.ModTime(), info.ModTime())
+	buf.Reset()
+	mgr.WriteContext(&buf)
+	if got := codeFile.loadedDefs[0].Content.StartLine; got != 3 {
+		t.Fatalf("definition is refreshed without a change, start line %d", got)
+	}
+	mgr.OnFileChanged([]string{"./a.go", "b.go"})
+	buf.Reset()
+	mgr.WriteContext(&buf)
+	if got := codeFile.loadedDefs[0].Content.StartLine; got != 4 || codeFile.changed {
+		t.Errorf("definition is not refreshed after the change, start line %d", got)
+	}
+}
@@ -0,0 +1,29 @@
+package context
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares got with testdata/<name>.golden, run `go test -update` to rewrite the golden file.
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file %s failed: %v, run go test -update to create it", path, err)
+	}
+	if got != string(want) {
+		t.Errorf("rendered context does not match %s, run go test -update if the change is expected\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package context
+
+// The lifecycle hooks are optional, a context manager implements the ones it needs and
+// the agent invokes them on every manager implementing them.
+
+// TaskStartHook is invoked before the first request of a new user task.
+type TaskStartHook interface {
+	OnTaskStart(prompt string)
+}
+
+// TurnEndHook is invoked after the response of every request is handled, including its tool calls.
+type TurnEndHook interface {
+	OnTurnEnd()
+}
+
+// FileChangedHook is invoked after a turn in which some tools changed the codebase files,
+// with the paths relative to the codebase root.
+type FileChangedHook interface {
+	OnFileChanged(paths []string)
+}
+
+// TaskEndHook is invoked after the user task is finished.
+type TaskEndHook interface {
+	OnTaskEnd()
+}
+
+// FileChangeReporter is implemented by managers whose tools change the codebase files, ChangedFiles
+// returns the files changed since the last call, the agent passes them to the FileChangedHook managers.
+type FileChangeReporter interface {
+	ChangedFiles() []string
+}
+
+// Managers holding resources implement io.Closer, Close is invoked when the agent is closed.
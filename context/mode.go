@@ -0,0 +1,34 @@
+package context
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Mode decides what the agent does with a task, it selects the system prompt, the tools and the guardrails.
+type Mode string
+
+const (
+	// ModeAsk answers questions about the codebase, no file is modified.
+	ModeAsk Mode = "ask"
+	// ModePlan makes a numbered plan for the task and stores it, no file is modified.
+	ModePlan Mode = "plan"
+	// ModeEdit modifies the codebase files, following the stored plan if there is one.
+	ModeEdit Mode = "edit"
+)
+
+var Modes = []Mode{ModeAsk, ModePlan, ModeEdit}
+
+func ParseMode(s string) (Mode, error) {
+	mode := Mode(s)
+	if !slices.Contains(Modes, mode) {
+		return "", fmt.Errorf("unknown mode %q, available modes: %v", s, Modes)
+	}
+	return mode, nil
+}
+
+// ModeAware is implemented by managers whose tools and context depend on the mode,
+// SetMode is invoked before GetToolDef when the mode of the next task is decided.
+type ModeAware interface {
+	SetMode(mode Mode)
+}
@@ -7,8 +7,10 @@ import (
 	"llm_dev/codebase/impl"
 	"llm_dev/model"
 	"llm_dev/utils"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -90,6 +92,9 @@ type OutlineContextMgr struct {
 	buildCtxOp *impl.BuildCodeBaseCtxOps
 
 	fileTree *FileTreeNode
+	// overview is the file tree rendered at the first request of a task, it is kept for the whole task
+	// so the cached request prefix does not change when files are added.
+	overview string
 }
 
 func NewOutlineCtxMgr(root string, buildOp *impl.BuildCodeBaseCtxOps) OutlineContextMgr {
@@ -108,8 +113,8 @@ func (mgr *OutlineContextMgr) walkNode(node *FileTreeNode, handler func(*FileTre
 		return
 	}
 	handler(node)
-	for _, child := range node.children {
-		mgr.walkNode(child, handler)
+	for _, name := range slices.Sorted(maps.Keys(node.children)) {
+		mgr.walkNode(node.children[name], handler)
 	}
 }
 
@@ -160,7 +165,8 @@ func (mgr *OutlineContextMgr) writeLeafNode(buf *bytes.Buffer, path string, isDi
 	}
 	if isDir {
 		buf.WriteString(fmt.Sprintf("# %s\n\n", path))
-		for path, fc := range defByFile {
+		for _, path := range slices.Sorted(maps.Keys(defByFile)) {
+			fc := defByFile[path]
 			file := filepath.Join(mgr.rootPath, path)
 			buf.WriteString(fmt.Sprintf("- %s\n", path))
 			err := fc.WriteContent(buf, file)
@@ -211,6 +217,9 @@ func (mgr *OutlineContextMgr) writeFileTree(buf *bytes.Buffer) {
 			continue
 		}
 		relPath, _ := filepath.Rel(mgr.rootPath, file.Path)
+		if relPath == ".git" || strings.HasPrefix(relPath, ".git/") {
+			continue
+		}
 		entries, err := os.ReadDir(file.Path)
 		if err != nil {
 			continue
@@ -256,10 +265,23 @@ To help user with task, you should use this section to:
 }
 
 func (mgr *OutlineContextMgr) WriteContext(buf *bytes.Buffer) {
-	// mgr.writeFileTree(buf)
 	// mgr.writeOutline(buf)
 }
 
+// OnTaskStart drops the rendered file tree, it is rendered again for the new task.
+func (mgr *OutlineContextMgr) OnTaskStart(prompt string) {
+	mgr.overview = ""
+}
+
+func (mgr *OutlineContextMgr) WriteStableContext(buf *bytes.Buffer) {
+	if mgr.overview == "" {
+		var tree bytes.Buffer
+		mgr.writeFileTree(&tree)
+		mgr.overview = tree.String()
+	}
+	buf.WriteString(mgr.overview)
+}
+
 func (mgr *OutlineContextMgr) writeOverview(buf *bytes.Buffer, path string) {
 	node, err := mgr.findFileTreeNode(path)
 	if err != nil {
@@ -0,0 +1,150 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm_dev/codebase/impl"
+	"llm_dev/model"
+	"sync"
+)
+
+// FactoryEnv is what a context manager factory gets to build a manager for a codebase.
+type FactoryEnv struct {
+	Root     string
+	BuildOp  *impl.BuildCodeBaseCtxOps
+	Embedder model.Embedder
+}
+
+// Factory builds a context manager, options are the manager options in the config file, nil if not set.
+type Factory func(env FactoryEnv, options json.RawMessage) (ContextMgr, error)
+
+type registration struct {
+	name           string
+	factory        Factory
+	enabledDefault bool
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []registration
+)
+
+// Register adds a context manager factory under name, the managers are created in the order they are
+// registered. A manager enabled by default is used unless the profile disables it. Register panics if
+// the name is registered twice, it is meant to be called from init functions.
+func Register(name string, factory Factory, enabledDefault bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, reg := range registry {
+		if reg.name == name {
+			panic(fmt.Sprintf("context manager %s is registered twice", name))
+		}
+	}
+	registry = append(registry, registration{name: name, factory: factory, enabledDefault: enabledDefault})
+}
+
+// Registered returns the names of the registered context managers in registration order.
+func Registered() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	res := make([]string, len(registry))
+	for i, reg := range registry {
+		res[i] = reg.name
+	}
+	return res
+}
+
+// MgrConfig is the config of a context manager in a profile.
+type MgrConfig struct {
+	// Enabled keeps the registered default when it is not set, so a config only setting the options
+	// does not turn the manager off.
+	Enabled *bool           `json:"enabled,omitempty"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// enabled tells if the manager is enabled, enabledDefault applies when Enabled is not set.
+func (cfg MgrConfig) enabled(enabledDefault bool) bool {
+	if cfg.Enabled == nil {
+		return enabledDefault
+	}
+	return *cfg.Enabled
+}
+
+// NamedMgr is a created context manager with its registered name.
+type NamedMgr struct {
+	Name string
+	Mgr  ContextMgr
+}
+
+// NewMgrs creates the enabled context managers in registration order. A manager not in configs, or
+// whose config does not set Enabled, is enabled if it is enabled by default, configs naming an
+// unregistered manager are an error.
+func NewMgrs(env FactoryEnv, configs map[string]MgrConfig) ([]NamedMgr, error) {
+	registryMu.Lock()
+	regs := append([]registration{}, registry...)
+	registryMu.Unlock()
+	for name := range configs {
+		found := false
+		for _, reg := range regs {
+			found = found || reg.name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("context manager %s is not registered", name)
+		}
+	}
+	res := []NamedMgr{}
+	for _, reg := range regs {
+		cfg := configs[reg.name]
+		if !cfg.enabled(reg.enabledDefault) {
+			continue
+		}
+		mgr, err := reg.factory(env, cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("create context manager %s failed: %w", reg.name, err)
+		}
+		res = append(res, NamedMgr{Name: reg.name, Mgr: mgr})
+	}
+	return res, nil
+}
+
+type searchOptions struct {
+	// Semantic enables the semantic_search tool, it needs the embeddings of the codebase built with
+	// llm_dev embed. If it is not set, the tool is enabled only when the embeddings of the model exist.
+	Semantic *bool `json:"semantic"`
+}
+
+func init() {
+	Register("callgraph", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		mgr := NewCallGraphMgr(env.Root, env.BuildOp)
+		return &mgr, nil
+	}, true)
+	Register("outline", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		mgr := NewOutlineCtxMgr(env.Root, env.BuildOp)
+		mgr.OpenDir(".")
+		return &mgr, nil
+	}, true)
+	Register("search", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		opts := searchOptions{}
+		if options != nil {
+			if err := json.Unmarshal(options, &opts); err != nil {
+				return nil, err
+			}
+		}
+		embedder := env.Embedder
+		switch {
+		case opts.Semantic != nil && !*opts.Semantic:
+			embedder = nil
+		case opts.Semantic == nil && embedder != nil && !env.BuildOp.HasEmbeddings(embedder.Name()):
+			embedder = nil
+		}
+		mgr := NewSearchCtxMgr(env.Root, env.BuildOp, embedder)
+		return &mgr, nil
+	}, true)
+	Register("build", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		return &BuildContextMgr{}, nil
+	}, true)
+	Register("file", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		mgr := NewFileCtxMgr(env.Root, env.BuildOp)
+		return &mgr, nil
+	}, true)
+}
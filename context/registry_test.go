@@ -0,0 +1,102 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"llm_dev/codebase/impl"
+	"llm_dev/model"
+	"slices"
+	"testing"
+)
+
+type customCtxMgr struct {
+	options string
+}
+
+func (mgr *customCtxMgr) WriteContext(buf *bytes.Buffer) {
+	buf.WriteString(mgr.options)
+}
+func (mgr *customCtxMgr) GetToolDef() []model.ToolDef {
+	return nil
+}
+func (mgr *customCtxMgr) SaveState() (json.RawMessage, error) {
+	return nil, nil
+}
+func (mgr *customCtxMgr) RestoreState(state json.RawMessage) error {
+	return nil
+}
+
+func init() {
+	Register("test_custom", func(env FactoryEnv, options json.RawMessage) (ContextMgr, error) {
+		return &customCtxMgr{options: string(options)}, nil
+	}, false)
+}
+
+func TestNewMgrs(t *testing.T) {
+	root := t.TempDir()
+	env := FactoryEnv{
+		Root:     root,
+		BuildOp:  &impl.BuildCodeBaseCtxOps{RootPath: root},
+		Embedder: &model.StubEmbedder{Dim: 8},
+	}
+	enabled, disabled := true, false
+	tests := []struct {
+		name    string
+		configs map[string]MgrConfig
+		want    []string
+		wantErr bool
+	}{
+		{name: "default", configs: nil, want: []string{"callgraph", "outline", "search", "build", "file"}},
+		{name: "disable build", configs: map[string]MgrConfig{"build": {Enabled: &disabled}}, want: []string{"callgraph", "outline", "search", "file"}},
+		{
+			name:    "options only keep the default",
+			configs: map[string]MgrConfig{"search": {Options: json.RawMessage(`{"semantic": false}`)}, "test_custom": {Options: json.RawMessage(`{}`)}},
+			want:    []string{"callgraph", "outline", "search", "build", "file"},
+		},
+		{
+			name:    "enable custom",
+			configs: map[string]MgrConfig{"test_custom": {Enabled: &enabled, Options: json.RawMessage(`{"team":"x"}`)}},
+			want:    []string{"callgraph", "outline", "search", "build", "file", "test_custom"},
+		},
+		{name: "unknown manager", configs: map[string]MgrConfig{"missing": {Enabled: &enabled}}, wantErr: true},
+		{name: "invalid options", configs: map[string]MgrConfig{"search": {Enabled: &enabled, Options: json.RawMessage(`[]`)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgrs, err := NewMgrs(env, tt.configs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMgrs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := []string{}
+			for _, named := range mgrs {
+				got = append(got, named.Name)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("NewMgrs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("search options", func(t *testing.T) {
+		// without a database no embedding exists, the default leaves semantic_search out
+		for _, semantic := range []*bool{&enabled, &disabled, nil} {
+			options, _ := json.Marshal(map[string]*bool{"semantic": semantic})
+			mgrs, err := NewMgrs(env, map[string]MgrConfig{"search": {Enabled: &enabled, Options: options}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tools := []string{}
+			for _, named := range mgrs {
+				if named.Name != "search" {
+					continue
+				}
+				for _, tool := range named.Mgr.GetToolDef() {
+					tools = append(tools, tool.Name)
+				}
+			}
+			if want := semantic != nil && *semantic; slices.Contains(tools, "semantic_search") != want {
+				t.Errorf("semantic = %s, tools = %v", options, tools)
+			}
+		}
+	})
+}
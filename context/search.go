@@ -0,0 +1,405 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"llm_dev/codebase/impl"
+	"llm_dev/database"
+	"llm_dev/model"
+	"llm_dev/utils"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	maxSymbolResult   = 20
+	maxSemanticResult = 10
+)
+
+var searchSymbol = openai.FunctionDefinition{
+	Name:   "search_symbol",
+	Strict: true,
+	Description: `
+This tool is used for searching definitions by name when you do not know the exact file or identifier.
+The query is matched fuzzily against definition names, it supports:
+- exact or case insensitive name, e.g. "BuildCodeBaseCtxOps"
+- prefix or substring, e.g. "BuildCode", "CtxOps"
+- camel case initials or word prefixes, e.g. "BCBCO", "FileCtxMgr", "newFileCtx"
+- abbreviation, e.g. "fndDefs" for "FindDefs"
+
+<example>
+function call: search_symbol query = "FindDefs", kind = "", package = "". find all definitions named like FindDefs.
+function call: search_symbol query = "ctxmgr", kind = "type", package = "context". find types like ContextMgr declared in directory context.
+</example>
+
+The result is ranked by how well the name matches, each result shows the file path, line number and the declaration line.
+Use 'load_definition_context' tool with the file and name to load the complete implementation.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"query": {
+				Type:        jsonschema.String,
+				Description: "the symbol name or part of the name to search, e.g. FindDefs",
+			},
+			"kind": {
+				Type:        jsonschema.String,
+				Enum:        []string{"", "function", "method", "type", "var"},
+				Description: "only search definitions of this kind, empty string for all kinds",
+			},
+			"package": {
+				Type:        jsonschema.String,
+				Description: "only search definitions declared under this directory, e.g. codebase/impl, empty string for the whole codebase",
+			},
+		},
+		Required: []string{"query", "kind", "package"},
+	},
+}
+
+var semanticSearch = openai.FunctionDefinition{
+	Name:   "semantic_search",
+	Strict: true,
+	Description: `
+This tool is used for searching definitions by meaning, when you do not know the symbol name or the exact text in the code.
+The query is a natural language description of the functionality, the result is the definitions whose content is most similar to the query.
+
+<example>
+function call: semantic_search query = "where do we handle retry logic", top_k = 5. find the 5 definitions most related to retrying.
+function call: semantic_search query = "connect to the mongodb database", top_k = 3.
+</example>
+
+Each result shows the similarity score, the file path, line number and the declaration line.
+Use 'load_definition_context' tool with the file and name to load the complete implementation.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"query": {
+				Type:        jsonschema.String,
+				Description: "the natural language description of the code to search",
+			},
+			"top_k": {
+				Type:        jsonschema.Integer,
+				Description: fmt.Sprintf("the number of definitions to return, at most %d", maxSemanticResult),
+			},
+		},
+		Required: []string{"query", "top_k"},
+	},
+}
+
+type SearchContextMgr struct {
+	rootPath    string
+	buildCtxOps *impl.BuildCodeBaseCtxOps
+	embedder    model.Embedder
+}
+
+// NewSearchCtxMgr creates the search context manager, the semantic_search tool is
+// only provided when embedder is not nil.
+func NewSearchCtxMgr(root string, buildOp *impl.BuildCodeBaseCtxOps, embedder model.Embedder) SearchContextMgr {
+	return SearchContextMgr{
+		rootPath:    root,
+		buildCtxOps: buildOp,
+		embedder:    embedder,
+	}
+}
+
+type symbolMatch struct {
+	def   impl.Definition
+	score int
+}
+
+func (mgr *SearchContextMgr) searchSymbol(query string, kind string, pkg string) []symbolMatch {
+	builder := database.NewFilter()
+	if kind != "" {
+		builder.AddKV("keyword.0", kind)
+	} else {
+		builder.AddFilter("keyword.0", database.NewFilterKV(database.In, impl.SymbolKinds))
+	}
+	pkg = filepath.Clean(pkg)
+	if pkg != "." {
+		builder.AddKV("relfile", bson.M{
+			"$regex": fmt.Sprintf("^%s(/|$)", regexp.QuoteMeta(pkg)),
+		})
+	}
+	defs := mgr.buildCtxOps.FindDefs(builder.Build())
+	return rankSymbols(query, defs)
+}
+
+func rankSymbols(query string, defs []impl.Definition) []symbolMatch {
+	res := []symbolMatch{}
+	for _, def := range defs {
+		score, ok := fuzzyScore(query, def.Identifier)
+		if !ok {
+			continue
+		}
+		res = append(res, symbolMatch{def: def, score: score})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		if len(res[i].def.Identifier) != len(res[j].def.Identifier) {
+			return len(res[i].def.Identifier) < len(res[j].def.Identifier)
+		}
+		if res[i].def.RelFile != res[j].def.RelFile {
+			return res[i].def.RelFile < res[j].def.RelFile
+		}
+		return res[i].def.Content.StartLine < res[j].def.Content.StartLine
+	})
+	if len(res) > maxSymbolResult {
+		res = res[:maxSymbolResult]
+	}
+	return res
+}
+
+// fuzzyScore scores how well query matches the identifier name, higher is better.
+// It returns false if the query does not match at all.
+func fuzzyScore(query string, name string) (int, bool) {
+	if query == "" || name == "" {
+		return 0, false
+	}
+	lowerQuery := strings.ToLower(query)
+	lowerName := strings.ToLower(name)
+	switch {
+	case query == name:
+		return 1000, true
+	case lowerQuery == lowerName:
+		return 900, true
+	case strings.HasPrefix(lowerName, lowerQuery):
+		return 800 - (len(name) - len(query)), true
+	case matchCamelCase(lowerQuery, splitWords(name)):
+		return 700 - (len(name) - len(query)), true
+	case strings.Contains(lowerName, lowerQuery):
+		return 600 - strings.Index(lowerName, lowerQuery), true
+	}
+	return subsequenceScore(lowerQuery, name)
+}
+
+// splitWords splits an identifier into lower case words by camel case humps, digits and underscores,
+// e.g. "BuildCodeBaseCtxOps" -> ["build", "code", "base", "ctx", "ops"], "HTTPServer" -> ["http", "server"].
+func splitWords(name string) []string {
+	runes := []rune(name)
+	words := []string{}
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			words = append(words, strings.ToLower(string(runes[start:end])))
+		}
+		start = end
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' {
+			flush(i)
+			start = i + 1
+			continue
+		}
+		if i == 0 {
+			continue
+		}
+		prev := runes[i-1]
+		switch {
+		case unicode.IsUpper(r) && unicode.IsLower(prev):
+			flush(i)
+		case unicode.IsUpper(r) && unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			flush(i)
+		case unicode.IsDigit(r) != unicode.IsDigit(prev) && prev != '_':
+			flush(i)
+		}
+	}
+	flush(len(runes))
+	return words
+}
+
+// matchCamelCase reports whether query can be split into pieces where each piece is
+// a prefix of consecutive words, e.g. "bcbco" and "filectxmgr" match "BuildCodeBaseCtxOps" and "FileContentCtxMgr".
+// Words may be skipped, but the first word must match.
+func matchCamelCase(query string, words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	var match func(q string, idx int, first bool) bool
+	match = func(q string, idx int, first bool) bool {
+		if q == "" {
+			return true
+		}
+		for i := idx; i < len(words); i++ {
+			word := words[i]
+			for n := min(len(word), len(q)); n > 0; n-- {
+				if q[:n] == word[:n] && match(q[n:], i+1, false) {
+					return true
+				}
+			}
+			if first {
+				return false
+			}
+		}
+		return false
+	}
+	return match(query, 0, true)
+}
+
+// subsequenceScore matches query as a subsequence of name, rewarding matches on word
+// starts and consecutive characters.
+func subsequenceScore(lowerQuery string, name string) (int, bool) {
+	runes := []rune(name)
+	query := []rune(lowerQuery)
+	score := 100
+	qi := 0
+	prevMatch := -2
+	for i, r := range runes {
+		if qi == len(query) {
+			break
+		}
+		if unicode.ToLower(r) != query[qi] {
+			continue
+		}
+		wordStart := i == 0 || unicode.IsUpper(r) && !unicode.IsUpper(runes[i-1]) || runes[i-1] == '_'
+		if wordStart {
+			score += 10
+		}
+		if prevMatch == i-1 {
+			score += 5
+		}
+		prevMatch = i
+		qi++
+	}
+	if qi != len(query) {
+		return 0, false
+	}
+	score -= len(runes) - len(query)
+	return max(score, 1), true
+}
+
+func symbolDesc(keyword []string) string {
+	if len(keyword) >= 3 && keyword[0] == "method" {
+		return fmt.Sprintf("%s %s.%s", keyword[0], keyword[2], keyword[1])
+	}
+	return strings.Join(keyword[:min(len(keyword), 2)], " ")
+}
+
+func (mgr *SearchContextMgr) genSymbolOutput(query string, matches []symbolMatch) string {
+	var buf bytes.Buffer
+	if len(matches) == 0 {
+		buf.WriteString(fmt.Sprintf("no definition found matching %q\n", query))
+		return buf.String()
+	}
+	buf.WriteString(fmt.Sprintf("Found %d definitions matching %q, ranked by relevance:\n\n", len(matches), query))
+	for i, match := range matches {
+		def := match.def
+		buf.WriteString(fmt.Sprintf("%d. %s:%d %s\n", i+1, def.RelFile, def.Content.StartLine, symbolDesc(def.Keyword)))
+		fc := utils.FileContent{}
+		fc.AddChunk(utils.Range{
+			StartLine: def.Summary.StartLine,
+			EndLine:   def.Summary.StartLine + 1,
+		})
+		fc.WriteContent(&buf, filepath.Join(mgr.rootPath, def.RelFile))
+	}
+	return buf.String()
+}
+
+func (mgr *SearchContextMgr) genSemanticOutput(query string, matches []impl.ScoredDef) string {
+	var buf bytes.Buffer
+	if len(matches) == 0 {
+		buf.WriteString(fmt.Sprintf("no definition found related to %q\n", query))
+		return buf.String()
+	}
+	buf.WriteString(fmt.Sprintf("Found %d definitions related to %q, ranked by similarity:\n\n", len(matches), query))
+	for i, match := range matches {
+		def := match.Def
+		buf.WriteString(fmt.Sprintf("%d. [%.3f] %s:%d %s\n", i+1, match.Score, def.RelFile, def.Content.StartLine, symbolDesc(def.Keyword)))
+		fc := utils.FileContent{}
+		fc.AddChunk(utils.Range{
+			StartLine: def.Summary.StartLine,
+			EndLine:   def.Summary.StartLine + 1,
+		})
+		fc.WriteContent(&buf, filepath.Join(mgr.rootPath, def.RelFile))
+	}
+	return buf.String()
+}
+
+func (mgr *SearchContextMgr) WriteContext(buf *bytes.Buffer) {
+}
+
+// WriteStableContext tells when to use the search tools, semantic_search is only mentioned when it is
+// registered.
+func (mgr *SearchContextMgr) WriteStableContext(buf *bytes.Buffer) {
+	buf.WriteString(`
+### Search the codebase ###
+
+- If you only know part of a symbol name, use 'search_symbol' tool to find where the definition is declared.
+- If you are looking for some string, log message or error text, use 'search_code' tool to find where it appears.
+`)
+	if mgr.embedder != nil {
+		buf.WriteString("- If you only know what the code does but not its name or text, use 'semantic_search' tool to find the related definitions.\n")
+	}
+}
+
+func (mgr *SearchContextMgr) GetToolDef() []model.ToolDef {
+	searchSymbolHandler := func(argsStr string) (string, error) {
+		args := struct {
+			Query   string
+			Kind    string
+			Package string
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		matches := mgr.searchSymbol(args.Query, args.Kind, args.Package)
+		return mgr.genSymbolOutput(args.Query, matches), nil
+	}
+	searchCodeHandler := func(argsStr string) (string, error) {
+		args := struct {
+			Pattern      string
+			Path         string
+			Glob         string
+			ContextLines int `json:"context_lines"`
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		re, err := regexp.Compile(args.Pattern)
+		if err != nil {
+			return fmt.Sprintf("invalid regular expression %q, error: %v\n", args.Pattern, err), nil
+		}
+		contextLines := uint(min(max(args.ContextLines, 0), maxContextLines))
+		hits, truncated := mgr.grepFiles(re, args.Path, args.Glob)
+		return mgr.genCodeOutput(args.Pattern, hits, truncated, contextLines), nil
+	}
+	semanticSearchHandler := func(argsStr string) (string, error) {
+		args := struct {
+			Query string
+			TopK  int `json:"top_k"`
+		}{}
+		err := json.Unmarshal([]byte(argsStr), &args)
+		if err != nil {
+			return "", err
+		}
+		topK := min(max(args.TopK, 1), maxSemanticResult)
+		matches, err := mgr.buildCtxOps.SemanticSearch(mgr.embedder, args.Query, topK)
+		if err != nil {
+			return err.Error(), nil
+		}
+		return mgr.genSemanticOutput(args.Query, matches), nil
+	}
+	res := []model.ToolDef{
+		{FunctionDefinition: searchSymbol, Handler: searchSymbolHandler, ReadOnly: true},
+		{FunctionDefinition: searchCode, Handler: searchCodeHandler, ReadOnly: true},
+	}
+	if mgr.embedder != nil {
+		res = append(res, model.ToolDef{FunctionDefinition: semanticSearch, Handler: semanticSearchHandler, ReadOnly: true})
+	}
+	return res
+}
@@ -0,0 +1,206 @@
+package context
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"llm_dev/codebase/impl"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const (
+	maxCodeHit        = 50
+	maxCodeOutputSize = 16 * 1024
+	maxCodeFileSize   = 1024 * 1024
+	maxContextLines   = 10
+)
+
+var searchCode = openai.FunctionDefinition{
+	Name:   "search_code",
+	Strict: true,
+	Description: `
+This tool is used for searching the content of the files in the codebase with a regular expression, like grep.
+Use this tool to find where some string, log message, error text or code pattern appears.
+Files ignored by .gitignore are not searched.
+
+<example>
+function call: search_code pattern = "find exact one def fail", path = "", glob = "", context_lines = 0. find where the log message is written.
+function call: search_code pattern = "func \\(op \\*BuildCodeBaseCtxOps\\) Find\\w+", path = "codebase", glob = "*.go", context_lines = 2. find methods of BuildCodeBaseCtxOps starting with Find in go files under codebase.
+</example>
+
+Each matched line shows the enclosing definition, use 'load_definition_context' tool with the file and definition name to load the complete implementation.
+	`,
+	Parameters: jsonschema.Definition{
+		Type:                 jsonschema.Object,
+		AdditionalProperties: false,
+		Properties: map[string]jsonschema.Definition{
+			"pattern": {
+				Type:        jsonschema.String,
+				Description: "the regular expression to search, in Go RE2 syntax, e.g. load file .* failed",
+			},
+			"path": {
+				Type:        jsonschema.String,
+				Description: "only search files under this directory or the file itself, e.g. codebase/impl, empty string for the whole codebase",
+			},
+			"glob": {
+				Type:        jsonschema.String,
+				Description: "only search files whose name or path matches the glob pattern, e.g. *.go, context/*.go, empty string for all files",
+			},
+			"context_lines": {
+				Type:        jsonschema.Integer,
+				Description: fmt.Sprintf("the number of lines to show before and after each match, at most %d", maxContextLines),
+			},
+		},
+		Required: []string{"pattern", "path", "glob", "context_lines"},
+	},
+}
+
+type codeHit struct {
+	file string
+	line uint
+}
+
+// grepFiles walks the project files under path and returns the lines matching re.
+// The second return value reports whether the result is truncated.
+func (mgr *SearchContextMgr) grepFiles(re *regexp.Regexp, path string, glob string) ([]codeHit, bool) {
+	path = filepath.Clean(path)
+	hits := []codeHit{}
+	truncated := false
+	fileChan := mgr.buildCtxOps.WalkProjectFileTree()
+	for file := range fileChan {
+		if truncated || file.D.IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(mgr.rootPath, file.Path)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(relPath, ".git/") {
+			continue
+		}
+		if path != "." && relPath != path && !strings.HasPrefix(relPath, path+"/") {
+			continue
+		}
+		if !matchGlob(glob, relPath) {
+			continue
+		}
+		for _, line := range grepFile(re, file.Path) {
+			if len(hits) == maxCodeHit {
+				truncated = true
+				break
+			}
+			hits = append(hits, codeHit{file: relPath, line: line})
+		}
+	}
+	return hits, truncated
+}
+
+func matchGlob(glob string, relPath string) bool {
+	if glob == "" {
+		return true
+	}
+	if ok, _ := filepath.Match(glob, relPath); ok {
+		return true
+	}
+	ok, _ := filepath.Match(glob, filepath.Base(relPath))
+	return ok
+}
+
+func grepFile(re *regexp.Regexp, path string) []uint {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxCodeFileSize {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+		return nil
+	}
+	res := []uint{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxCodeFileSize)
+	lineNum := uint(0)
+	for scanner.Scan() {
+		lineNum++
+		if re.Match(scanner.Bytes()) {
+			res = append(res, lineNum)
+		}
+	}
+	return res
+}
+
+// findEnclosingDef returns the innermost definition whose content contains the line.
+func findEnclosingDef(defs []impl.Definition, line uint) *impl.Definition {
+	var res *impl.Definition
+	for i, def := range defs {
+		if line < def.Content.StartLine || line >= def.Content.EndLine {
+			continue
+		}
+		if res == nil || def.Content.EndLine-def.Content.StartLine < res.Content.EndLine-res.Content.StartLine {
+			res = &defs[i]
+		}
+	}
+	return res
+}
+
+func (mgr *SearchContextMgr) genCodeOutput(pattern string, hits []codeHit, truncated bool, contextLines uint) string {
+	var buf bytes.Buffer
+	if len(hits) == 0 {
+		buf.WriteString(fmt.Sprintf("no match found for pattern %q\n", pattern))
+		return buf.String()
+	}
+	hitsByFile := make(map[string][]uint)
+	for _, hit := range hits {
+		hitsByFile[hit.file] = append(hitsByFile[hit.file], hit.line)
+	}
+	files := make([]string, 0, len(hitsByFile))
+	for file := range hitsByFile {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	buf.WriteString(fmt.Sprintf("Found %d matches for pattern %q in %d files:\n\n", len(hits), pattern, len(files)))
+	for i, file := range files {
+		if buf.Len() > maxCodeOutputSize {
+			buf.WriteString(fmt.Sprintf("... output truncated, %d more files omitted\n", len(files)-i))
+			return buf.String()
+		}
+		lines := hitsByFile[file]
+		var defs []impl.Definition
+		if filepath.Ext(file) == ".go" {
+			defs = mgr.buildCtxOps.FindDefs(impl.GenDefFilter(&file, nil, nil))
+		}
+		buf.WriteString(fmt.Sprintf("# %s\n", file))
+		fc := utils.FileContent{}
+		for _, line := range lines {
+			start := uint(1)
+			if line > contextLines {
+				start = line - contextLines
+			}
+			fc.AddChunk(utils.Range{
+				StartLine: start,
+				EndLine:   line + contextLines + 1,
+			})
+			def := findEnclosingDef(defs, line)
+			if def != nil && def.Identifier != "" {
+				buf.WriteString(fmt.Sprintf("- line %d in %s\n", line, symbolDesc(def.Keyword)))
+			} else {
+				buf.WriteString(fmt.Sprintf("- line %d\n", line))
+			}
+		}
+		buf.WriteByte('\n')
+		fc.WriteContent(&buf, filepath.Join(mgr.rootPath, file))
+		buf.WriteByte('\n')
+	}
+	if truncated {
+		buf.WriteString(fmt.Sprintf("IMPORTANT: only the first %d matches are shown, use a more specific pattern, path or glob to narrow the search.\n", maxCodeHit))
+	}
+	return buf.String()
+}
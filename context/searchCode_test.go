@@ -0,0 +1,99 @@
+package context
+
+import (
+	"llm_dev/codebase/impl"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearchContextMgr_grepFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		".gitignore":     "build/\n",
+		"main.go":        "package main\n\nfunc main() {\n\tlog(\"load file failed\")\n}\n",
+		"docs/README.md": "# docs\nload file failed when missing\n",
+		"build/out.go":   "load file failed\n",
+	})
+	mgr := NewSearchCtxMgr(root, &impl.BuildCodeBaseCtxOps{RootPath: root}, nil)
+	re := regexp.MustCompile("load file .* failed|load file failed")
+
+	tests := []struct {
+		name string
+		path string
+		glob string
+		want []codeHit
+	}{
+		{
+			name: "search whole codebase respecting gitignore",
+			want: []codeHit{{file: "docs/README.md", line: 2}, {file: "main.go", line: 4}},
+		},
+		{
+			name: "search with glob",
+			glob: "*.go",
+			want: []codeHit{{file: "main.go", line: 4}},
+		},
+		{
+			name: "search under path",
+			path: "docs",
+			want: []codeHit{{file: "docs/README.md", line: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := mgr.grepFiles(re, tt.path, tt.glob)
+			if truncated {
+				t.Errorf("grepFiles truncated")
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("grepFiles = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("grepFiles[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFindEnclosingDef(t *testing.T) {
+	defs := []impl.Definition{
+		{Identifier: "Mgr", Content: utils.Range{StartLine: 1, EndLine: 20}},
+		{Identifier: "load", Content: utils.Range{StartLine: 5, EndLine: 10}},
+	}
+	tests := []struct {
+		line uint
+		want string
+	}{
+		{line: 3, want: "Mgr"},
+		{line: 7, want: "load"},
+		{line: 10, want: "Mgr"},
+		{line: 25, want: ""},
+	}
+	for _, tt := range tests {
+		got := findEnclosingDef(defs, tt.line)
+		name := ""
+		if got != nil {
+			name = got.Identifier
+		}
+		if name != tt.want {
+			t.Errorf("findEnclosingDef(%d) = %q, want %q", tt.line, name, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package context
+
+import (
+	"bytes"
+	"llm_dev/codebase/impl"
+	"llm_dev/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "BuildCodeBaseCtxOps", want: []string{"build", "code", "base", "ctx", "ops"}},
+		{name: "HTTPServer", want: []string{"http", "server"}},
+		{name: "load_file_context", want: []string{"load", "file", "context"}},
+		{name: "utf8Text", want: []string{"utf", "8", "text"}},
+		{name: "x", want: []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitWords(tt.name)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWords(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		match bool
+	}{
+		{query: "FindDefs", name: "FindDefs", match: true},
+		{query: "finddefs", name: "FindDefs", match: true},
+		{query: "BCBCO", name: "BuildCodeBaseCtxOps", match: true},
+		{query: "FileCtxMgr", name: "FileContentCtxMgr", match: true},
+		{query: "CtxOps", name: "BuildCodeBaseCtxOps", match: true},
+		{query: "fndDefs", name: "FindDefs", match: true},
+		{query: "xyz", name: "FindDefs", match: false},
+		{query: "", name: "FindDefs", match: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+"_"+tt.name, func(t *testing.T) {
+			_, ok := fuzzyScore(tt.query, tt.name)
+			if ok != tt.match {
+				t.Errorf("fuzzyScore(%q, %q) match = %v, want %v", tt.query, tt.name, ok, tt.match)
+			}
+		})
+	}
+}
+
+func TestRankSymbols(t *testing.T) {
+	t.Run("rank exact prefix camel case and subsequence", func(t *testing.T) {
+		defs := []impl.Definition{
+			{Identifier: "findDefsInFile", RelFile: "a.go"},
+			{Identifier: "FindDefs", RelFile: "b.go"},
+			{Identifier: "FindOneDef", RelFile: "b.go"},
+			{Identifier: "GenDefFilter", RelFile: "b.go"},
+			{Identifier: "insertDefs", RelFile: "b.go"},
+		}
+		got := rankSymbols("FindDefs", defs)
+		want := []string{"FindDefs", "findDefsInFile"}
+		if len(got) != len(want) {
+			t.Fatalf("rankSymbols returned %d results, want %d", len(got), len(want))
+		}
+		for i, name := range want {
+			if got[i].def.Identifier != name {
+				t.Errorf("result %d = %s, want %s", i, got[i].def.Identifier, name)
+			}
+		}
+	})
+}
+
+func TestSearchContextMgr_WriteStableContext(t *testing.T) {
+	for _, embedder := range []model.Embedder{nil, &model.StubEmbedder{Dim: 8}} {
+		mgr := NewSearchCtxMgr(t.TempDir(), &impl.BuildCodeBaseCtxOps{}, embedder)
+		var buf bytes.Buffer
+		mgr.WriteStableContext(&buf)
+		for _, def := range mgr.GetToolDef() {
+			if !strings.Contains(buf.String(), "'"+def.Name+"'") {
+				t.Errorf("the stable context does not mention the registered tool %s", def.Name)
+			}
+		}
+		if mentioned := strings.Contains(buf.String(), "semantic_search"); mentioned != (embedder != nil) {
+			t.Errorf("semantic_search is mentioned = %v with embedder %v", mentioned, embedder)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package context
+
+import (
+	"encoding/json"
+	"llm_dev/codebase/impl"
+	"llm_dev/utils"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The state of the context managers is saved with the session as JSON, it only keeps what the
+// model loaded, everything derived from the codebase is resolved again after restoring.
+
+type loadedDefState struct {
+	Def      impl.Definition `json:"def"`
+	LastUsed uint64          `json:"last_used"`
+	Text     string          `json:"text,omitempty"`
+	Note     string          `json:"note,omitempty"`
+}
+
+type loadedRangeState struct {
+	Range    utils.Range `json:"range"`
+	LastUsed uint64      `json:"last_used"`
+}
+
+type codeFileState struct {
+	Path        string             `json:"path"`
+	Defs        []impl.Definition  `json:"defs,omitempty"`
+	SummaryUsed uint64             `json:"summary_used,omitempty"`
+	LoadedDefs  []loadedDefState   `json:"loaded_defs,omitempty"`
+	Ranges      []loadedRangeState `json:"ranges,omitempty"`
+	ModTime     time.Time          `json:"mod_time"`
+}
+
+type fileContentState struct {
+	Clock     uint64          `json:"clock"`
+	TurnClock []uint64        `json:"turn_clock"`
+	Files     []codeFileState `json:"files"`
+}
+
+func (mgr *FileContentCtxMgr) SaveState() (json.RawMessage, error) {
+	state := fileContentState{
+		Clock:     mgr.clock,
+		TurnClock: mgr.turnClock,
+		Files:     []codeFileState{},
+	}
+	for _, path := range slices.Sorted(maps.Keys(mgr.autoLoadCtx)) {
+		file := mgr.autoLoadCtx[path]
+		fileState := codeFileState{
+			Path:        file.path,
+			Defs:        file.defs,
+			SummaryUsed: file.summaryUsed,
+			ModTime:     file.modTime,
+		}
+		for _, def := range file.loadedDefs {
+			fileState.LoadedDefs = append(fileState.LoadedDefs, loadedDefState{
+				Def:      def.Definition,
+				LastUsed: def.lastUsed,
+				Text:     def.text,
+				Note:     def.note,
+			})
+		}
+		for _, r := range file.ranges {
+			fileState.Ranges = append(fileState.Ranges, loadedRangeState{Range: r.Range, LastUsed: r.lastUsed})
+		}
+		state.Files = append(state.Files, fileState)
+	}
+	return json.Marshal(state)
+}
+
+// RestoreState replaces the loaded context with the saved one. The modification time is restored too,
+// so the files changed since the session was saved are re-resolved and noted at the next render.
+func (mgr *FileContentCtxMgr) RestoreState(data json.RawMessage) error {
+	state := fileContentState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	mgr.clock = state.Clock
+	mgr.turnClock = state.TurnClock
+	mgr.removed = nil
+	mgr.autoLoadCtx = make(map[string]*CodeFile, len(state.Files))
+	for _, fileState := range state.Files {
+		file := NewCodeFile(fileState.Path)
+		file.defs = fileState.Defs
+		file.summaryUsed = fileState.SummaryUsed
+		file.modTime = fileState.ModTime
+		for _, def := range fileState.LoadedDefs {
+			file.loadedDefs = append(file.loadedDefs, loadedDef{
+				Definition: def.Def,
+				lastUsed:   def.LastUsed,
+				text:       def.Text,
+				note:       def.Note,
+			})
+		}
+		for _, r := range fileState.Ranges {
+			file.ranges = append(file.ranges, loadedRange{Range: r.Range, lastUsed: r.LastUsed})
+		}
+		mgr.autoLoadCtx[file.path] = &file
+	}
+	return nil
+}
+
+type outlineState struct {
+	// Open are the opened directories, parents before children.
+	Open []string `json:"open"`
+}
+
+func (mgr *OutlineContextMgr) SaveState() (json.RawMessage, error) {
+	state := outlineState{Open: []string{}}
+	mgr.walkNode(mgr.fileTree, func(node *FileTreeNode) {
+		if node.isOpen {
+			state.Open = append(state.Open, node.relpath)
+		}
+	})
+	return json.Marshal(state)
+}
+
+func (mgr *OutlineContextMgr) RestoreState(data json.RawMessage) error {
+	state := outlineState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	mgr.fileTree.close()
+	for _, path := range state.Open {
+		if _, err := mgr.OpenDir(path); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("restore opened directory fail")
+		}
+	}
+	return nil
+}
+
+type buildState struct {
+	Plan    *Plan    `json:"plan,omitempty"`
+	Actions []Action `json:"actions"`
+}
+
+func (mgr *BuildContextMgr) SaveState() (json.RawMessage, error) {
+	return json.Marshal(buildState{Plan: mgr.plan, Actions: mgr.actions})
+}
+
+func (mgr *BuildContextMgr) RestoreState(data json.RawMessage) error {
+	state := buildState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	mgr.plan = state.Plan
+	mgr.actions = state.Actions
+	return nil
+}
+
+// The call graph and search managers only provide tools, they have no state to save.
+
+func (mgr *CallGraphContextMgr) SaveState() (json.RawMessage, error) {
+	return nil, nil
+}
+
+func (mgr *CallGraphContextMgr) RestoreState(data json.RawMessage) error {
+	return nil
+}
+
+func (mgr *SearchContextMgr) SaveState() (json.RawMessage, error) {
+	return nil, nil
+}
+
+func (mgr *SearchContextMgr) RestoreState(data json.RawMessage) error {
+	return nil
+}
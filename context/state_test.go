@@ -0,0 +1,118 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"llm_dev/codebase/impl"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileContentCtxMgr_SaveState(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"a.go":      "package a\n\nfunc A() {\n}\n",
+		"README.md": "line 1\nline 2\nline 3\n",
+	})
+	op := &impl.BuildCodeBaseCtxOps{RootPath: root}
+	mgr := NewFileCtxMgr(root, op)
+	codeFile := NewCodeFile("a.go")
+	mgr.autoLoadCtx["a.go"] = &codeFile
+	codeFile.loadedDefs = addDefs(nil, []impl.Definition{
+		{Identifier: "A", Keyword: []string{"function", "A"}, Content: utils.Range{StartLine: 3, EndLine: 5}},
+	}, mgr.tick())
+	mgr.loadLines("README.md", 2, 3)
+	mgr.AgeContext()
+	var want bytes.Buffer
+	mgr.WriteContext(&want)
+
+	state, err := mgr.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewFileCtxMgr(root, op)
+	if err := restored.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	restored.WriteContext(&got)
+	if got.String() != want.String() {
+		t.Errorf("restored context differs\ngot:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+	if restored.clock != mgr.clock || !slices.Equal(restored.turnClock, mgr.turnClock) {
+		t.Errorf("clock is not restored")
+	}
+
+	t.Run("file changed after save", func(t *testing.T) {
+		writeTestFiles(t, root, map[string]string{"a.go": "package a\n\n// A does nothing\nfunc A() {\n}\n"})
+		future := time.Now().Add(time.Hour)
+		os.Chtimes(filepath.Join(root, "a.go"), future, future)
+		changed := NewFileCtxMgr(root, op)
+		if err := changed.RestoreState(state); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		changed.WriteContext(&buf)
+		def := changed.autoLoadCtx["a.go"].loadedDefs[0]
+		if def.Content.StartLine != 4 || def.note == "" {
+			t.Errorf("definition is not re-resolved after restore: %+v", def)
+		}
+	})
+	t.Run("invalid state", func(t *testing.T) {
+		invalid := NewFileCtxMgr(root, op)
+		if err := invalid.RestoreState(json.RawMessage("{")); err == nil {
+			t.Errorf("RestoreState() expects an error")
+		}
+	})
+}
+
+func TestOutlineContextMgr_SaveState(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"a/b/c.go": "package b\n",
+		"d/e.go":   "package d\n",
+	})
+	op := &impl.BuildCodeBaseCtxOps{RootPath: root}
+	mgr := NewOutlineCtxMgr(root, op)
+	for _, dir := range []string{".", "a", "a/b"} {
+		if _, err := mgr.OpenDir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := mgr.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewOutlineCtxMgr(root, op)
+	if err := restored.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	open := []string{}
+	restored.walkNode(restored.fileTree, func(node *FileTreeNode) {
+		if node.isOpen {
+			open = append(open, node.relpath)
+		}
+	})
+	if want := []string{".", "a", "a/b"}; !slices.Equal(open, want) {
+		t.Errorf("opened directories = %v, want %v", open, want)
+	}
+}
+
+func TestBuildContextMgr_SaveState(t *testing.T) {
+	mgr := BuildContextMgr{actions: []Action{{Type: "Edit", Thought: "fix", Result: "done"}}}
+	state, err := mgr.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := BuildContextMgr{}
+	if err := restored.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restored.actions, mgr.actions) {
+		t.Errorf("actions = %v, want %v", restored.actions, mgr.actions)
+	}
+}
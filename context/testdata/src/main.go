@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+var greeting = "hello"
+
+func main() {
+	fmt.Println(greeting)
+}
+
+func helper() int {
+	return 1
+}
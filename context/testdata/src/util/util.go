@@ -0,0 +1,9 @@
+package util
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Inc() {
+	c.n++
+}
@@ -2,34 +2,418 @@ package main
 
 import (
 	"bufio"
+	stdcontext "context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"llm_dev/agent"
 	"llm_dev/codebase/impl"
+	"llm_dev/context"
 	"llm_dev/database"
+	"llm_dev/mcp"
+	"llm_dev/server"
+	"llm_dev/utils"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
 )
 
 var sss string
 
+const codebaseRoot = "/root/workspace/llm_dev"
+
 func main() {
+	cmd := "chat"
+	args := os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "chat":
+		if code := runChat(args); code != 0 {
+			os.Exit(code)
+		}
+	case "sessions":
+		listSessions(args)
+	case "serve":
+		runServe(args)
+	case "mcp":
+		runMCP(args)
+	case "embed":
+		runEmbed(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", cmd)
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "  llm_dev chat [--config <file>] [--profile <name>] [--resume <id>]  chat with the agent, resume a saved session by id")
+		fmt.Fprintln(os.Stderr, "  llm_dev sessions [--config <file>]                                 list the saved sessions")
+		fmt.Fprintln(os.Stderr, "  llm_dev serve [--config <file>] [--profile <name>] [--addr <addr>] serve the agent sessions over HTTP")
+		fmt.Fprintln(os.Stderr, "  llm_dev mcp [--config <file>] [--profile <name>] [--mode <mode>]   serve the codebase tools as an MCP server over stdio")
+		fmt.Fprintln(os.Stderr, "  llm_dev embed                                                      build the semantic search index of the indexed definitions")
+		os.Exit(2)
+	}
+}
+
+// runChat chats with the agent until the input ends or Ctrl-C exits, it returns the exit code once the
+// session is saved.
+func runChat(args []string) int {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	resume := flags.String("resume", "", "id of the saved session to resume")
+	configPath := flags.String("config", "", "path of the JSON config file, default "+agent.DefaultConfigPath())
+	profile := flags.String("profile", "", "name of the profile in the config file, e.g. explain")
+	flags.Parse(args)
+	cfg := loadConfig(*configPath)
+	if *profile != "" {
+		cfg.Profile = *profile
+	}
 
 	database.InitDB()
 	defer database.CloseDB()
 	op := impl.BuildCodeBaseCtxOps{
-		RootPath: "/root/workspace/llm_dev",
+		RootPath: codebaseRoot,
 		Db:       database.GetDBClient().Database("llm_dev"),
 	}
 	op.RootPath = ""
 	// op.GenAllUsedDefs()
 	// op.SetMinPreFix()
 	model := agent.NewModel("http://192.168.65.2:4000", "sk-1234")
-	agent := agent.NewBaseAgent("/root/workspace/llm_dev", *model)
+	agent := agent.NewBaseAgent(codebaseRoot, *model)
+	if err := agent.SetConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "apply config failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer agent.Close()
+	agent.SetEventHandler(terminalEvents(os.Stdout, "context.log"))
+	store := newSessionStore(cfg)
+	if *resume != "" {
+		session, err := agent.ResumeSession(store, *resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "resume session failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("resumed session %s, %d tasks in history\n", session.ID, len(session.History))
+	} else {
+		session := agent.StartSession(store)
+		fmt.Printf("started session %s\n", session.ID)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	interrupts := newInterruptHandler(sigs)
+	return chat(&agent, readLines(os.Stdin), interrupts, func(line string) { runCommand(&agent, line) })
+}
+
+// chatAgent is the part of the agent used by the chat loop.
+type chatAgent interface {
+	Mode() context.Mode
+	NewUserTask(taskCtx stdcontext.Context, userprompt string) agent.TaskOutcome
+}
+
+// chat runs the prompts read from lines, the lines starting with "/" are commands. It returns 0 when
+// lines is closed and 130 when Ctrl-C exits, the caller then saves the session.
+func chat(a chatAgent, lines <-chan string, interrupts *interruptHandler, command func(line string)) int {
 	for {
-		reader := bufio.NewScanner(os.Stdin)
-		fmt.Print("User Prompt> ")
-		reader.Scan() // This will read a line of input from the user
-		userprompt := reader.Text()
+		fmt.Printf("User Prompt [%s]> ", a.Mode())
+		var userprompt string
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return 0
+			}
+			userprompt = line
+		case <-interrupts.exit:
+			return 130
+		}
+		if strings.HasPrefix(userprompt, "/") {
+			command(userprompt)
+			continue
+		}
+
+		taskCtx := interrupts.startTask()
+		a.NewUserTask(taskCtx, userprompt)
+		interrupts.endTask()
+		select {
+		case <-interrupts.exit:
+			return 130
+		default:
+		}
+	}
+}
+
+// readLines reads the lines of r on a goroutine, so the chat loop can wait for a line and Ctrl-C at once.
+// The channel is closed at the end of r.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// interruptHandler cancels the running task at the first Ctrl-C and goes back to the prompt, Ctrl-C
+// again before the task returns or at the prompt closes exit, the chat loop then returns once the task
+// is remembered.
+type interruptHandler struct {
+	mu      sync.Mutex
+	cancel  stdcontext.CancelFunc
+	exiting bool
+	exit    chan struct{}
+}
+
+func newInterruptHandler(sigs <-chan os.Signal) *interruptHandler {
+	handler := &interruptHandler{exit: make(chan struct{})}
+	go func() {
+		for range sigs {
+			handler.interrupt()
+		}
+	}()
+	return handler
+}
+
+func (handler *interruptHandler) interrupt() {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	switch {
+	case handler.cancel != nil:
+		fmt.Println("\ninterrupted, press Ctrl-C again to exit")
+		handler.cancel()
+		handler.cancel = nil
+	case !handler.exiting:
+		fmt.Println("\nexit")
+		handler.exiting = true
+		close(handler.exit)
+	}
+}
+
+func (handler *interruptHandler) startTask() stdcontext.Context {
+	taskCtx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	handler.mu.Lock()
+	handler.cancel = cancel
+	handler.mu.Unlock()
+	return taskCtx
+}
+
+func (handler *interruptHandler) endTask() {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.cancel != nil {
+		handler.cancel()
+		handler.cancel = nil
+	}
+}
+
+// runServe serves the agent sessions and the OpenAI compatible chat completion endpoint over HTTP until
+// Ctrl-C, every session and every chat completion request has its own agent.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", server.DefaultAddr, "address to listen on, only bind a non-loopback address on a trusted network")
+	allowHosts := flags.String("allow-hosts", "", "comma separated host names accepted besides localhost, for a non-loopback address")
+	configPath := flags.String("config", "", "path of the JSON config file, default "+agent.DefaultConfigPath())
+	profile := flags.String("profile", "", "name of the profile in the config file, e.g. explain")
+	flags.Parse(args)
+	cfg := loadConfig(*configPath)
+	if *profile != "" {
+		cfg.Profile = *profile
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	model := agent.NewModel("http://192.168.65.2:4000", "sk-1234")
+	store := newSessionStore(cfg)
+	srv := server.New(func(resume string) (server.Agent, error) {
+		baseAgent := agent.NewBaseAgent(codebaseRoot, *model)
+		if err := baseAgent.SetConfig(cfg); err != nil {
+			return nil, err
+		}
+		if resume != "" {
+			if _, err := baseAgent.ResumeSession(store, resume); err != nil {
+				return nil, err
+			}
+		} else {
+			baseAgent.StartSession(store)
+		}
+		return &baseAgent, nil
+	})
+	if !server.IsLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "warning: %s accepts remote connections, anyone reaching it can read and edit %s\n", *addr, codebaseRoot)
+		if host, _, err := net.SplitHostPort(*addr); err == nil && host != "" {
+			srv.AllowHost(host)
+		}
+	}
+	for _, host := range strings.Split(*allowHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			srv.AllowHost(host)
+		}
+	}
+	srv.EnableCompletions(func() (server.CompletionAgent, error) {
+		baseAgent := agent.NewBaseAgent(codebaseRoot, *model)
+		if err := baseAgent.SetConfig(cfg); err != nil {
+			return nil, err
+		}
+		return &baseAgent, nil
+	})
+	httpServer := &http.Server{Addr: *addr, Handler: srv.Handler()}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		fmt.Println("\nshutting down")
+		srv.Close()
+		shutdownCtx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	fmt.Printf("serving on http://%s\n", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "serve failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runMCP serves the tools and the context of the context managers over stdio until stdin is closed.
+func runMCP(args []string) {
+	flags := flag.NewFlagSet("mcp", flag.ExitOnError)
+	configPath := flags.String("config", "", "path of the JSON config file, default "+agent.DefaultConfigPath())
+	profile := flags.String("profile", "", "name of the profile in the config file, e.g. explain")
+	mode := flags.String("mode", "", "mode selecting the tools, ask, plan or edit, default the mode of the config")
+	flags.Parse(args)
+	cfg := loadConfig(*configPath)
+	if *profile != "" {
+		cfg.Profile = *profile
+	}
+	if *mode != "" {
+		parsed, err := context.ParseMode(*mode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		cfg.Mode = parsed
+	}
+	protocolOut := protocolOutput()
+
+	database.InitDB()
+	defer database.CloseDB()
+	model := agent.NewModel("http://192.168.65.2:4000", "sk-1234")
+	baseAgent := agent.NewBaseAgent(codebaseRoot, *model)
+	if err := baseAgent.SetConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "apply config failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer baseAgent.Close()
+	srv := mcp.NewServer("llm_dev", "0.1.0", baseAgent.ContextMgrs())
+	ctx, stop := signal.NotifyContext(stdcontext.Background(), os.Interrupt)
+	defer stop()
+	if err := srv.Serve(ctx, os.Stdin, protocolOut); err != nil && !errors.Is(err, stdcontext.Canceled) {
+		fmt.Fprintf(os.Stderr, "mcp server failed: %v\n", err)
+	}
+}
+
+// protocolOutput returns stdout for the protocol, the log and the output printed by the tools go to
+// stderr from then on.
+func protocolOutput() *os.File {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	utils.SetLogOutput(os.Stderr)
+	return out
+}
+
+// runEmbed computes the embeddings of the definitions in the index, the semantic_search tool is enabled
+// by default once they exist.
+func runEmbed(args []string) {
+	flags := flag.NewFlagSet("embed", flag.ExitOnError)
+	flags.Parse(args)
+
+	database.InitDB()
+	defer database.CloseDB()
+	op := impl.BuildCodeBaseCtxOps{
+		RootPath: codebaseRoot,
+		Db:       database.GetDBClient().Database("llm_dev"),
+	}
+	model := agent.NewModel("http://192.168.65.2:4000", "sk-1234")
+	embedder := model.Embedder()
+	if err := op.GenAllEmbeddings(embedder); err != nil {
+		fmt.Fprintf(os.Stderr, "build the semantic index failed: %v\n", err)
+		database.CloseDB()
+		os.Exit(1)
+	}
+	fmt.Printf("semantic index built for %s\n", embedder.Name())
+}
+
+func loadConfig(path string) agent.AgentConfig {
+	cfg, err := agent.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config failed: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func newSessionStore(cfg agent.AgentConfig) *agent.SessionStore {
+	return agent.NewSessionStore(cfg.SessionDir)
+}
+
+func listSessions(args []string) {
+	flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+	configPath := flags.String("config", "", "path of the JSON config file, default "+agent.DefaultConfigPath())
+	flags.Parse(args)
+	sessions, err := newSessionStore(loadConfig(*configPath)).List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list sessions failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(sessions) == 0 {
+		fmt.Println("no saved session")
+		return
+	}
+	for _, session := range sessions {
+		fmt.Printf("%s  %s  %d tasks  %s\n", session.ID, session.Updated.Format("2006-01-02 15:04"), len(session.History), session.Title)
+	}
+}
 
-		agent.NewUserTask(userprompt)
+// runCommand runs the REPL commands starting with "/".
+func runCommand(baseAgent *agent.BaseAgent, line string) {
+	args := strings.Fields(line)
+	switch args[0] {
+	case "/history":
+		if len(args) > 1 && args[1] == "clear" {
+			baseAgent.ClearHistory()
+			fmt.Println("history cleared")
+			return
+		}
+		baseAgent.WriteHistory(os.Stdout)
+	case "/mode":
+		if len(args) == 1 {
+			fmt.Printf("current mode %s, available modes: %v\n", baseAgent.Mode(), context.Modes)
+			return
+		}
+		mode, err := context.ParseMode(args[1])
+		if err == nil {
+			err = baseAgent.SetMode(mode)
+		}
+		if err != nil {
+			fmt.Printf("switch mode failed: %v\n", err)
+			return
+		}
+		fmt.Printf("switched to %s mode\n", mode)
+	case "/session":
+		if session := baseAgent.Session(); session != nil {
+			fmt.Printf("session %s, resume it with: llm_dev chat --resume %s\n", session.ID, session.ID)
+		}
+	default:
+		fmt.Printf("unknown command %s, available commands:\n", args[0])
+		fmt.Println("/history        show the summaries of the finished tasks")
+		fmt.Println("/history clear  clear the task history")
+		fmt.Println("/mode           show the current mode")
+		fmt.Println("/mode <mode>    switch to ask, plan or edit mode for the following tasks")
+		fmt.Println("/session        show the id of the current session")
 	}
 }
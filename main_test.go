@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"llm_dev/agent"
+	"llm_dev/context"
+	"llm_dev/mcp"
+	"llm_dev/model"
+	"llm_dev/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeChatAgent runs every task until it is interrupted and remembers it, as the agent does, Close saves
+// the history as the session.
+type fakeChatAgent struct {
+	store   *agent.SessionStore
+	started chan string
+	session agent.Session
+}
+
+func (a *fakeChatAgent) Mode() context.Mode { return context.ModeAsk }
+
+func (a *fakeChatAgent) NewUserTask(taskCtx stdcontext.Context, userprompt string) agent.TaskOutcome {
+	a.started <- userprompt
+	<-taskCtx.Done()
+	a.session.History = append(a.session.History, agent.TaskMemory{Prompt: userprompt, Summary: "interrupted"})
+	return agent.TaskOutcome{Status: agent.OutcomeAborted}
+}
+
+func (a *fakeChatAgent) Close() error { return a.store.Save(&a.session) }
+
+func TestChat_doubleInterrupt(t *testing.T) {
+	store := agent.NewSessionStore(filepath.Join(t.TempDir(), "sessions"))
+	a := &fakeChatAgent{store: store, started: make(chan string, 1), session: agent.Session{ID: "s1"}}
+	sigs := make(chan os.Signal)
+	interrupts := newInterruptHandler(sigs)
+	lines := make(chan string, 1)
+	lines <- "fix the bug"
+
+	code := make(chan int, 1)
+	go func() {
+		// runChat closes the agent when chat returns, which saves the session
+		exitCode := chat(a, lines, interrupts, func(string) { t.Errorf("unexpected command") })
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+		code <- exitCode
+	}()
+	select {
+	case <-a.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the task is not started")
+	}
+	sigs <- os.Interrupt
+	sigs <- os.Interrupt
+	select {
+	case got := <-code:
+		if got != 130 {
+			t.Errorf("exit code = %d, want 130", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("chat does not return after the second interrupt")
+	}
+
+	session, err := store.Load("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.History) != 1 || session.History[0].Prompt != "fix the bug" {
+		t.Errorf("saved history = %+v, want the interrupted task", session.History)
+	}
+}
+
+func TestChat_interruptAtPrompt(t *testing.T) {
+	sigs := make(chan os.Signal)
+	interrupts := newInterruptHandler(sigs)
+	code := make(chan int, 1)
+	go func() {
+		code <- chat(&fakeChatAgent{}, make(chan string), interrupts, nil)
+	}()
+	sigs <- os.Interrupt
+	select {
+	case got := <-code:
+		if got != 130 {
+			t.Errorf("exit code = %d, want 130", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("chat does not return at the prompt")
+	}
+}
+
+// noisyMgr has a tool which logs and prints, as the tools of the codebase managers do.
+type noisyMgr struct{}
+
+func (noisyMgr) WriteContext(buf *bytes.Buffer) {}
+func (noisyMgr) GetToolDef() []model.ToolDef {
+	return []model.ToolDef{{
+		FunctionDefinition: openai.FunctionDefinition{Name: "noisy", Description: "log and print"},
+		Handler: func(args string) (string, error) {
+			log.Info().Msg("evict the old files")
+			fmt.Println("printed by the tool")
+			return "done", nil
+		},
+	}}
+}
+func (noisyMgr) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (noisyMgr) RestoreState(state json.RawMessage) error { return nil }
+
+func TestProtocolOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, origStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, stderr
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, origStderr
+		utils.SetLogOutput(stdout)
+	})
+	utils.SetLogOutput(w)
+
+	protocolOut := protocolOutput()
+	log.Info().Msg("connect to mongodb")
+	srv := mcp.NewServer("llm_dev", "test", []context.NamedMgr{{Name: "noisy", Mgr: noisyMgr{}}})
+	requests := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"noisy"}}`,
+	}, "\n") + "\n"
+	if err := srv.Serve(t.Context(), strings.NewReader(requests), protocolOut); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	frames := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		frame := struct {
+			JSONRPC string `json:"jsonrpc"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil || frame.JSONRPC != "2.0" {
+			t.Errorf("the protocol output has a line which is not a JSON-RPC frame: %s", scanner.Text())
+			continue
+		}
+		frames++
+	}
+	if frames != 2 {
+		t.Errorf("%d frames, want 2", frames)
+	}
+	logged, _ := os.ReadFile(stderr.Name())
+	if !strings.Contains(string(logged), "connect to mongodb") || !strings.Contains(string(logged), "printed by the tool") {
+		t.Errorf("the log and the tool output are not sent to stderr: %s", logged)
+	}
+}
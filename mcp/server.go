@@ -0,0 +1,321 @@
+// Package mcp serves the tools and the loaded context of the context managers to other agents with the
+// Model Context Protocol over stdio. The messages are JSON-RPC 2.0, one per line.
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	llmctx "llm_dev/context"
+	"llm_dev/model"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ProtocolVersion is the latest MCP version supported, it is used when the client asks for an unknown one.
+const ProtocolVersion = "2025-06-18"
+
+var supportedVersions = []string{"2024-11-05", "2025-03-26", ProtocolVersion}
+
+// resourceScheme prefixes the URI of the context resource of every manager, e.g. context://outline.
+const resourceScheme = "context://"
+
+// maxMessageSize is the size of the largest message read, a tool call can carry a whole file.
+const maxMessageSize = 16 << 20
+
+// Server publishes the tools of the context managers as MCP tools and the context of every manager as a
+// resource. The requests are handled one at a time, the managers are not safe for concurrent use.
+type Server struct {
+	name    string
+	version string
+	mgrs    []llmctx.NamedMgr
+	// tools are the tools of the managers by name, in the order of the managers.
+	tools map[string]model.ToolDef
+	names []string
+}
+
+func NewServer(name string, version string, mgrs []llmctx.NamedMgr) *Server {
+	srv := &Server{
+		name:    name,
+		version: version,
+		mgrs:    mgrs,
+		tools:   make(map[string]model.ToolDef),
+	}
+	for _, named := range mgrs {
+		for _, def := range named.Mgr.GetToolDef() {
+			if _, exist := srv.tools[def.Name]; exist {
+				log.Error().Str("tool", def.Name).Str("manager", named.Name).Msg("tool already exist")
+				continue
+			}
+			srv.tools[def.Name] = def
+			srv.names = append(srv.names, def.Name)
+		}
+	}
+	return srv
+}
+
+// Serve reads the requests from r and writes the responses to w until r is closed or ctx is canceled.
+// r is read on a goroutine so that a blocked read does not delay the cancel, the goroutine returns at the
+// next line or at the end of r.
+func (srv *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+	enc := json.NewEncoder(w)
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != nil {
+					return fmt.Errorf("read request failed: %w", err)
+				}
+				return nil
+			}
+			line = bytes.TrimSpace(l)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		resp := srv.handleMessage(line)
+		if resp == nil {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return fmt.Errorf("write response failed: %w", err)
+		}
+	}
+}
+
+// handleMessage returns the response of the message, nil for a notification.
+func (srv *Server) handleMessage(line []byte) *response {
+	req := request{}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: newError(codeParseError, "invalid JSON: %v", err)}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if req.isNotification() {
+			return nil
+		}
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: newError(codeInvalidRequest, "not a JSON-RPC 2.0 request")}
+	}
+	if req.isNotification() {
+		// notifications/initialized and notifications/cancelled need no action, the requests are handled in order
+		log.Debug().Str("method", req.Method).Msg("mcp notification")
+		return nil
+	}
+	result, rpcErr := srv.handle(req)
+	resp := &response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+	if rpcErr != nil {
+		resp.Result = nil
+	}
+	return resp
+}
+
+func (srv *Server) handle(req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return srv.initialize(req.Params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return srv.listTools(), nil
+	case "tools/call":
+		return srv.callTool(req.Params)
+	case "resources/list":
+		return srv.listResources(), nil
+	case "resources/read":
+		return srv.readResource(req.Params)
+	default:
+		return nil, newError(codeMethodNotFound, "method %s is not supported", req.Method)
+	}
+}
+
+type initializeParams struct {
+	ProtocolVersion string `json:"protocolVersion"`
+}
+
+func (srv *Server) initialize(params json.RawMessage) (any, *rpcError) {
+	p := initializeParams{}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	version := ProtocolVersion
+	if slices.Contains(supportedVersions, p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools":     map[string]any{"listChanged": false},
+			"resources": map[string]any{"subscribe": false, "listChanged": false},
+		},
+		"serverInfo": map[string]string{"name": srv.name, "version": srv.version},
+		"instructions": "The tools search and load the context of the codebase, the loaded context is kept between " +
+			"the calls and can be read as the resources.",
+	}, nil
+}
+
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema any             `json:"inputSchema"`
+	Annotations toolAnnotations `json:"annotations"`
+}
+
+type toolAnnotations struct {
+	ReadOnlyHint bool `json:"readOnlyHint"`
+}
+
+func (srv *Server) listTools() any {
+	tools := []tool{}
+	for _, name := range srv.names {
+		def := srv.tools[name]
+		var schema any = map[string]any{"type": "object"}
+		if def.Parameters != nil {
+			schema = def.Parameters
+		}
+		tools = append(tools, tool{
+			Name:        def.Name,
+			Description: def.Description,
+			InputSchema: schema,
+			Annotations: toolAnnotations{ReadOnlyHint: def.ReadOnly},
+		})
+	}
+	return map[string]any{"tools": tools}
+}
+
+type callToolParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type callToolResult struct {
+	Content []textContent `json:"content"`
+	IsError bool          `json:"isError"`
+}
+
+// callTool runs the tool, a failed call is a result with isError set, so the calling model sees the error.
+// An unknown tool is a protocol error.
+func (srv *Server) callTool(params json.RawMessage) (any, *rpcError) {
+	p := callToolParams{}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	def, exist := srv.tools[p.Name]
+	if !exist {
+		return nil, newError(codeInvalidParams, "tool %s does not exist, the available tools are %v", p.Name, srv.names)
+	}
+	args := string(p.Arguments)
+	if len(p.Arguments) == 0 || args == "null" {
+		args = "{}"
+	}
+	res, err := srv.runTool(def, args)
+	srv.fileChanged()
+	if err != nil {
+		return callToolResult{Content: []textContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	return callToolResult{Content: []textContent{{Type: "text", Text: res}}}, nil
+}
+
+func (srv *Server) runTool(def model.ToolDef, args string) (res string, err error) {
+	if err := def.ValidateArgs(args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Any("panic", r).Str("tool", def.Name).Msg("tool handler panicked")
+			err = fmt.Errorf("the tool crashed: %v", r)
+		}
+	}()
+	return def.Handler(args)
+}
+
+// fileChanged passes the files changed by the tool call to the managers, as the agent does after a turn.
+func (srv *Server) fileChanged() {
+	changed := []string{}
+	for _, named := range srv.mgrs {
+		if reporter, ok := named.Mgr.(llmctx.FileChangeReporter); ok {
+			changed = append(changed, reporter.ChangedFiles()...)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	slices.Sort(changed)
+	changed = slices.Compact(changed)
+	for _, named := range srv.mgrs {
+		if hook, ok := named.Mgr.(llmctx.FileChangedHook); ok {
+			hook.OnFileChanged(changed)
+		}
+	}
+}
+
+type resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+func (srv *Server) listResources() any {
+	resources := []resource{}
+	for _, named := range srv.mgrs {
+		resources = append(resources, resource{
+			URI:         resourceScheme + named.Name,
+			Name:        named.Name,
+			Description: fmt.Sprintf("the context loaded by the %s tools", named.Name),
+			MimeType:    "text/plain",
+		})
+	}
+	return map[string]any{"resources": resources}
+}
+
+type readResourceParams struct {
+	URI string `json:"uri"`
+}
+
+// readResource renders the stable and the volatile context of the manager, as the agent sends them.
+func (srv *Server) readResource(params json.RawMessage) (any, *rpcError) {
+	p := readResourceParams{}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	for _, named := range srv.mgrs {
+		if resourceScheme+named.Name != p.URI {
+			continue
+		}
+		var buf bytes.Buffer
+		if stable, ok := named.Mgr.(llmctx.StableContextMgr); ok {
+			stable.WriteStableContext(&buf)
+		}
+		named.Mgr.WriteContext(&buf)
+		return map[string]any{
+			"contents": []map[string]string{{"uri": p.URI, "mimeType": "text/plain", "text": buf.String()}},
+		}, nil
+	}
+	return nil, newError(codeInvalidParams, "resource %s does not exist", p.URI)
+}
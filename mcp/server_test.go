@@ -0,0 +1,221 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	llmctx "llm_dev/context"
+	"llm_dev/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// fakeMgr loads files by name, reading "broken" fails and reading "panic" crashes.
+type fakeMgr struct {
+	loaded  []string
+	changed []string
+	notices [][]string
+}
+
+func (mgr *fakeMgr) WriteStableContext(buf *bytes.Buffer) { buf.WriteString("files of the demo\n") }
+
+func (mgr *fakeMgr) WriteContext(buf *bytes.Buffer) {
+	for _, file := range mgr.loaded {
+		buf.WriteString("FILE " + file + "\n")
+	}
+}
+
+func (mgr *fakeMgr) GetToolDef() []model.ToolDef {
+	params := jsonschema.Definition{
+		Type:       jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{"file": {Type: jsonschema.String}},
+		Required:   []string{"file"},
+	}
+	return []model.ToolDef{
+		{
+			FunctionDefinition: openai.FunctionDefinition{Name: "load_file", Description: "load a file", Parameters: params},
+			Handler: func(args string) (string, error) {
+				file := struct{ File string }{}
+				json.Unmarshal([]byte(args), &file)
+				switch file.File {
+				case "broken":
+					return "", errors.New("file broken is not readable")
+				case "panic":
+					panic("boom")
+				}
+				mgr.loaded = append(mgr.loaded, file.File)
+				mgr.changed = append(mgr.changed, file.File)
+				return "loaded " + file.File, nil
+			},
+		},
+		{
+			FunctionDefinition: openai.FunctionDefinition{Name: "list_files", Description: "list the files"},
+			Handler:            func(args string) (string, error) { return "a.go b.go", nil },
+			ReadOnly:           true,
+		},
+	}
+}
+
+func (mgr *fakeMgr) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (mgr *fakeMgr) RestoreState(state json.RawMessage) error { return nil }
+func (mgr *fakeMgr) ChangedFiles() []string {
+	res := mgr.changed
+	mgr.changed = nil
+	return res
+}
+func (mgr *fakeMgr) OnFileChanged(paths []string) { mgr.notices = append(mgr.notices, paths) }
+
+// serve sends the messages and returns the responses by id.
+func serve(t *testing.T, srv *Server, messages ...string) map[string]response {
+	t.Helper()
+	var out bytes.Buffer
+	if err := srv.Serve(t.Context(), strings.NewReader(strings.Join(messages, "\n")+"\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]response{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		resp := struct {
+			response
+			Result json.RawMessage `json:"result"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", scanner.Text(), err)
+		}
+		resp.response.Result = resp.Result
+		res[string(resp.ID)] = resp.response
+	}
+	return res
+}
+
+func result[T any](t *testing.T, resp response) T {
+	t.Helper()
+	var res T
+	if resp.Error != nil {
+		t.Fatalf("error %v", resp.Error)
+	}
+	if err := json.Unmarshal(resp.Result.(json.RawMessage), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestServer(t *testing.T) {
+	mgr := &fakeMgr{}
+	srv := NewServer("llm_dev", "test", []llmctx.NamedMgr{{Name: "files", Mgr: mgr}})
+	res := serve(t, srv,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"load_file","arguments":{"file":"main.go"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"load_file","arguments":{"path":"main.go"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"load_file","arguments":{"file":"broken"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"load_file","arguments":{"file":"panic"}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"delete_all"}}`,
+		`{"jsonrpc":"2.0","id":8,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":9,"method":"resources/read","params":{"uri":"context://files"}}`,
+		`{"jsonrpc":"2.0","id":10,"method":"resources/read","params":{"uri":"context://none"}}`,
+		`{"jsonrpc":"2.0","id":11,"method":"prompts/list"}`,
+		`{"jsonrpc":"2.0","id":"ping","method":"ping"}`,
+		`{not json`,
+	)
+	if len(res) != 13 {
+		t.Fatalf("%d responses, want 13, the notification has none", len(res))
+	}
+
+	initRes := result[struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		Capabilities    map[string]any `json:"capabilities"`
+		ServerInfo      struct{ Name string }
+	}](t, res["1"])
+	if initRes.ProtocolVersion != "2025-03-26" || initRes.ServerInfo.Name != "llm_dev" || initRes.Capabilities["tools"] == nil || initRes.Capabilities["resources"] == nil {
+		t.Errorf("initialize = %+v", initRes)
+	}
+
+	tools := result[struct{ Tools []tool }](t, res["2"]).Tools
+	if len(tools) != 2 || tools[0].Name != "load_file" || tools[1].Name != "list_files" || !tools[1].Annotations.ReadOnlyHint {
+		t.Fatalf("tools = %+v", tools)
+	}
+	if schema, _ := json.Marshal(tools[0].InputSchema); !strings.Contains(string(schema), `"required":["file"]`) {
+		t.Errorf("input schema = %s", schema)
+	}
+	if schema, _ := json.Marshal(tools[1].InputSchema); string(schema) != `{"type":"object"}` {
+		t.Errorf("input schema without parameters = %s", schema)
+	}
+
+	calls := map[string]struct {
+		text    string
+		isError bool
+	}{
+		"3": {"loaded main.go", false},
+		"4": {"invalid arguments: arguments misses the required field file", true},
+		"5": {"file broken is not readable", true},
+		"6": {"the tool crashed: boom", true},
+	}
+	for id, want := range calls {
+		got := result[callToolResult](t, res[id])
+		if len(got.Content) != 1 || got.Content[0].Type != "text" || got.Content[0].Text != want.text || got.IsError != want.isError {
+			t.Errorf("call %s = %+v, want %+v", id, got, want)
+		}
+	}
+	if len(mgr.notices) != 1 || mgr.notices[0][0] != "main.go" {
+		t.Errorf("file changed notices = %v", mgr.notices)
+	}
+
+	resources := result[struct{ Resources []resource }](t, res["8"]).Resources
+	if len(resources) != 1 || resources[0].URI != "context://files" {
+		t.Errorf("resources = %+v", resources)
+	}
+	contents := result[struct{ Contents []map[string]string }](t, res["9"]).Contents
+	if len(contents) != 1 || contents[0]["text"] != "files of the demo\nFILE main.go\n" {
+		t.Errorf("resource contents = %+v", contents)
+	}
+
+	errCodes := map[string]int{"7": codeInvalidParams, "10": codeInvalidParams, "11": codeMethodNotFound, "null": codeParseError}
+	for id, code := range errCodes {
+		if resp := res[id]; resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("response %s = %+v, want error %d", id, resp, code)
+		}
+	}
+	if res[`"ping"`].Error != nil {
+		t.Errorf("ping = %+v", res[`"ping"`])
+	}
+}
+
+func TestServer_initializeUnknownVersion(t *testing.T) {
+	srv := NewServer("llm_dev", "test", nil)
+	res := serve(t, srv, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+	initRes := result[struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}](t, res["1"])
+	if initRes.ProtocolVersion != ProtocolVersion {
+		t.Errorf("protocol version = %s, want %s", initRes.ProtocolVersion, ProtocolVersion)
+	}
+}
+
+func TestServer_cancelWhileReading(t *testing.T) {
+	srv := NewServer("llm_dev", "test", nil)
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx, r, io.Discard)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() does not return while the input is blocked")
+	}
+}
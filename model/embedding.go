@@ -0,0 +1,99 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type Embedder interface {
+	// Embed returns one vector for each text, in the same order.
+	Embed(texts []string) ([][]float32, error)
+	Name() string
+}
+
+// OpenAIEmbedder computes embeddings with an OpenAI compatible embeddings endpoint,
+// e.g. the LiteLLM proxy.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAIEmbedder(client *openai.Client, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		client: client,
+		model:  model,
+	}
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return e.model
+}
+
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	req := openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	}
+	resp, err := e.client.CreateEmbeddings(context.TODO(), req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding count %d does not match input count %d", len(resp.Data), len(texts))
+	}
+	res := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		res[data.Index] = data.Embedding
+	}
+	return res, nil
+}
+
+// StubEmbedder is a local embedder which hashes the words of the text into a fixed size vector.
+// It needs no external service and is used for tests.
+type StubEmbedder struct {
+	Dim int
+}
+
+func (e *StubEmbedder) Name() string {
+	return fmt.Sprintf("stub-%d", e.Dim)
+}
+
+func (e *StubEmbedder) Embed(texts []string) ([][]float32, error) {
+	res := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, e.Dim)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vec[h.Sum32()%uint32(e.Dim)] += 1
+		}
+		res[i] = vec
+	}
+	return res, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b, 0 if either is a zero vector.
+func CosineSimilarity(a []float32, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []float32
+		b    []float32
+		want float32
+	}{
+		{name: "same direction", a: []float32{1, 2, 3}, b: []float32{2, 4, 6}, want: 1},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 1}, want: 0},
+		{name: "opposite", a: []float32{1, 1}, b: []float32{-1, -1}, want: -1},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 1}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CosineSimilarity(tt.a, tt.b)
+			if math.Abs(float64(got-tt.want)) > 1e-6 {
+				t.Errorf("CosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStubEmbedder_Embed(t *testing.T) {
+	t.Run("similar text has higher similarity", func(t *testing.T) {
+		embedder := StubEmbedder{Dim: 256}
+		res, err := embedder.Embed([]string{
+			"retry the request with backoff",
+			"func retryRequest() { backoff(); retry() }",
+			"connect to mongodb database",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 3 || len(res[0]) != 256 {
+			t.Fatalf("unexpected embedding shape")
+		}
+		related := CosineSimilarity(res[0], res[1])
+		unrelated := CosineSimilarity(res[0], res[2])
+		if related <= unrelated {
+			t.Errorf("related similarity %v should be greater than unrelated %v", related, unrelated)
+		}
+	})
+}
@@ -22,6 +22,9 @@ type ToolHandler func(args string) (string, error)
 type ToolDef struct {
 	openai.FunctionDefinition
 	Handler ToolHandler
+	// ReadOnly tools only read the codebase and change no state of the context managers, the read-only
+	// calls of one response run concurrently.
+	ReadOnly bool
 }
 
 func SendReq(req *http.Request) (<-chan StreamRes, error) {
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+type cacheBreakpointsKey struct{}
+
+// WithCacheBreakpoints marks the messages at the indexes as prompt cache breakpoints for the chat
+// completion request sent with the returned context. go-openai has no cache_control field, so the
+// markers are added to the request body by CacheControlDoer.
+func WithCacheBreakpoints(ctx context.Context, indexes []int) context.Context {
+	return context.WithValue(ctx, cacheBreakpointsKey{}, indexes)
+}
+
+func cacheBreakpoints(ctx context.Context) []int {
+	indexes, _ := ctx.Value(cacheBreakpointsKey{}).([]int)
+	return indexes
+}
+
+// CacheControlDoer adds anthropic style cache_control markers to the messages marked by
+// WithCacheBreakpoints, the LiteLLM proxy passes them to the providers supporting prompt caching.
+type CacheControlDoer struct {
+	Doer openai.HTTPDoer
+}
+
+func NewCacheControlDoer(doer openai.HTTPDoer) *CacheControlDoer {
+	return &CacheControlDoer{Doer: doer}
+}
+
+func (d *CacheControlDoer) Do(req *http.Request) (*http.Response, error) {
+	indexes := cacheBreakpoints(req.Context())
+	if len(indexes) == 0 || req.Body == nil || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return d.Doer.Do(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	marked, err := addCacheControl(body, indexes)
+	if err != nil {
+		log.Error().Err(err).Msg("add cache control to request failed")
+		marked = body
+	}
+	req.Body = io.NopCloser(bytes.NewReader(marked))
+	req.ContentLength = int64(len(marked))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(marked)), nil
+	}
+	return d.Doer.Do(req)
+}
+
+var ephemeralCache = map[string]string{"type": "ephemeral"}
+
+// addCacheControl converts the content of the messages at the indexes into text parts and marks
+// the last part with cache_control, other fields of the request are kept as is.
+func addCacheControl(body []byte, indexes []int) ([]byte, error) {
+	req := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	messages := []map[string]any{}
+	if err := json.Unmarshal(req["messages"], &messages); err != nil {
+		return nil, err
+	}
+	for _, i := range indexes {
+		if i < 0 || i >= len(messages) {
+			continue
+		}
+		msg := messages[i]
+		switch content := msg["content"].(type) {
+		case string:
+			if content == "" {
+				continue
+			}
+			msg["content"] = []map[string]any{
+				{"type": "text", "text": content, "cache_control": ephemeralCache},
+			}
+		case []any:
+			if len(content) == 0 {
+				continue
+			}
+			if part, ok := content[len(content)-1].(map[string]any); ok {
+				part["cache_control"] = ephemeralCache
+			}
+		}
+	}
+	raw, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	req["messages"] = raw
+	return json.Marshal(req)
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type recordDoer struct {
+	body []byte
+}
+
+func (d *recordDoer) Do(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	d.body = body
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func TestCacheControlDoer_Do(t *testing.T) {
+	body := `{"model":"m","messages":[{"role":"system","content":"sys"},{"role":"user","content":"hi"},` +
+		`{"role":"assistant","content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]},{"role":"user","content":"ctx"}],"stream":true}`
+	tests := []struct {
+		name    string
+		url     string
+		indexes []int
+		want    []string
+	}{
+		{
+			name:    "mark string and part content",
+			url:     "http://proxy/v1/chat/completions",
+			indexes: []int{0, 2, 9},
+			want: []string{
+				`[{"cache_control":{"type":"ephemeral"},"text":"sys","type":"text"}]`,
+				`"hi"`,
+				`[{"text":"a","type":"text"},{"cache_control":{"type":"ephemeral"},"text":"b","type":"text"}]`,
+				`"ctx"`,
+			},
+		},
+		{
+			name:    "no breakpoints",
+			url:     "http://proxy/v1/chat/completions",
+			indexes: nil,
+			want:    []string{`"sys"`, `"hi"`, `[{"type":"text","text":"a"},{"type":"text","text":"b"}]`, `"ctx"`},
+		},
+		{
+			name:    "other endpoint",
+			url:     "http://proxy/v1/embeddings",
+			indexes: []int{0},
+			want:    []string{`"sys"`, `"hi"`, `[{"type":"text","text":"a"},{"type":"text","text":"b"}]`, `"ctx"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &recordDoer{}
+			doer := NewCacheControlDoer(record)
+			reqCtx := WithCacheBreakpoints(context.Background(), tt.indexes)
+			req, err := http.NewRequestWithContext(reqCtx, "POST", tt.url, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := doer.Do(req); err != nil {
+				t.Fatal(err)
+			}
+			got := struct {
+				Model    string
+				Stream   bool
+				Messages []struct {
+					Content json.RawMessage
+				}
+			}{}
+			if err := json.Unmarshal(record.body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Model != "m" || !got.Stream {
+				t.Errorf("request fields are not kept: %s", record.body)
+			}
+			if len(got.Messages) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(got.Messages), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if string(got.Messages[i].Content) != want {
+					t.Errorf("message %d content = %s, want %s", i, got.Messages[i].Content, want)
+				}
+			}
+		})
+	}
+}
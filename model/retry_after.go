@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type retryAfterKey struct{}
+
+// WithRetryAfter returns a context recording the Retry-After header of the response to the request
+// sent with it. go-openai drops the response headers of failed requests, so the header is captured by
+// RetryAfterDoer, the returned delay is 0 if the response has no Retry-After header.
+func WithRetryAfter(ctx context.Context) (context.Context, *time.Duration) {
+	delay := new(time.Duration)
+	return context.WithValue(ctx, retryAfterKey{}, delay), delay
+}
+
+// RetryAfterDoer records the Retry-After header for the requests sent with WithRetryAfter.
+type RetryAfterDoer struct {
+	Doer openai.HTTPDoer
+}
+
+func NewRetryAfterDoer(doer openai.HTTPDoer) *RetryAfterDoer {
+	return &RetryAfterDoer{Doer: doer}
+}
+
+func (d *RetryAfterDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.Doer.Do(req)
+	if delay, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+		*delay = 0
+		if err == nil {
+			*delay = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+	}
+	return resp, err
+}
+
+// ParseRetryAfter parses the Retry-After header in seconds or as an HTTP date, an invalid or past
+// value is 0.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type headerDoer struct {
+	header http.Header
+}
+
+func (d *headerDoer) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusTooManyRequests, Header: d.header, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{" 10 ", 10 * time.Second},
+		{"-1", 0},
+		{now.Add(7 * time.Second).Format(http.TimeFormat), 7 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfterDoer_Do(t *testing.T) {
+	doer := NewRetryAfterDoer(&headerDoer{header: http.Header{"Retry-After": []string{"2"}}})
+	ctx, delay := WithRetryAfter(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://proxy/v1/chat/completions", nil)
+	if _, err := doer.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if *delay != 2*time.Second {
+		t.Errorf("delay = %v, want 2s", *delay)
+	}
+
+	// requests without WithRetryAfter are passed through
+	req, _ = http.NewRequest(http.MethodPost, "http://proxy/v1/chat/completions", nil)
+	if _, err := doer.Do(req); err != nil {
+		t.Fatal(err)
+	}
+}
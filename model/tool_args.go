@@ -0,0 +1,110 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// ValidateArgs checks the JSON arguments of a tool call against the parameters schema of the tool.
+// jsonschema.Validate only tells if the arguments are valid, the error here names the invalid field,
+// so the model can fix the call. Tools without a jsonschema.Definition are not validated.
+func (def ToolDef) ValidateArgs(args string) error {
+	var schema jsonschema.Definition
+	switch params := def.Parameters.(type) {
+	case jsonschema.Definition:
+		schema = params
+	case *jsonschema.Definition:
+		if params == nil {
+			return nil
+		}
+		schema = *params
+	default:
+		return nil
+	}
+	var data any
+	if err := json.Unmarshal([]byte(args), &data); err != nil {
+		return fmt.Errorf("arguments are not valid JSON: %w", err)
+	}
+	return validateValue(schema, data, "arguments")
+}
+
+func validateValue(schema jsonschema.Definition, data any, path string) error {
+	if data == nil {
+		if schema.Nullable || schema.Type == jsonschema.Null {
+			return nil
+		}
+		return fmt.Errorf("%s must be %s, not null", path, schema.Type)
+	}
+	switch schema.Type {
+	case jsonschema.Object:
+		obj, ok := data.(map[string]any)
+		if !ok {
+			return typeError(schema, data, path)
+		}
+		for _, field := range schema.Required {
+			if _, exist := obj[field]; !exist {
+				return fmt.Errorf("%s misses the required field %s", path, field)
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(obj)) {
+			fieldSchema, exist := schema.Properties[key]
+			if !exist {
+				if additional, ok := schema.AdditionalProperties.(bool); ok && !additional {
+					return fmt.Errorf("%s has the unknown field %s, the fields are %v", path, key, slices.Sorted(maps.Keys(schema.Properties)))
+				}
+				continue
+			}
+			if err := validateValue(fieldSchema, obj[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	case jsonschema.Array:
+		arr, ok := data.([]any)
+		if !ok {
+			return typeError(schema, data, path)
+		}
+		if schema.Items == nil {
+			return nil
+		}
+		for i, item := range arr {
+			if err := validateValue(*schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case jsonschema.String:
+		str, ok := data.(string)
+		if !ok {
+			return typeError(schema, data, path)
+		}
+		if len(schema.Enum) != 0 && !slices.Contains(schema.Enum, str) {
+			return fmt.Errorf("%s must be one of %q, not %q", path, schema.Enum, str)
+		}
+	case jsonschema.Integer:
+		num, ok := data.(float64)
+		if !ok || num != float64(int64(num)) {
+			return typeError(schema, data, path)
+		}
+	case jsonschema.Number:
+		if _, ok := data.(float64); !ok {
+			return typeError(schema, data, path)
+		}
+	case jsonschema.Boolean:
+		if _, ok := data.(bool); !ok {
+			return typeError(schema, data, path)
+		}
+	}
+	return nil
+}
+
+func typeError(schema jsonschema.Definition, data any, path string) error {
+	value, _ := json.Marshal(data)
+	text := string(value)
+	if len(text) > 50 {
+		text = text[:50] + "..."
+	}
+	return fmt.Errorf("%s must be %s, got %s", path, schema.Type, text)
+}
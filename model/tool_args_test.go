@@ -0,0 +1,62 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+func TestToolDef_ValidateArgs(t *testing.T) {
+	def := ToolDef{FunctionDefinition: openai.FunctionDefinition{
+		Name: "load_lines",
+		Parameters: jsonschema.Definition{
+			Type:                 jsonschema.Object,
+			AdditionalProperties: false,
+			Properties: map[string]jsonschema.Definition{
+				"file":  {Type: jsonschema.String},
+				"start": {Type: jsonschema.Integer},
+				"kind":  {Type: jsonschema.String, Enum: []string{"func", "type"}},
+				"names": {Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.String}},
+			},
+			Required: []string{"file", "start"},
+		},
+	}}
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{name: "valid", args: `{"file":"a.go","start":3,"kind":"func","names":["A","B"]}`},
+		{name: "optional field missing", args: `{"file":"a.go","start":3}`},
+		{name: "invalid json", args: `{"file":"a.go"`, wantErr: "not valid JSON"},
+		{name: "not an object", args: `[1]`, wantErr: "arguments must be object"},
+		{name: "missing field", args: `{"file":"a.go"}`, wantErr: "misses the required field start"},
+		{name: "unknown field", args: `{"file":"a.go","start":3,"path":"b"}`, wantErr: "unknown field path"},
+		{name: "wrong type", args: `{"file":1,"start":3}`, wantErr: "arguments.file must be string, got 1"},
+		{name: "not integer", args: `{"file":"a.go","start":1.5}`, wantErr: "arguments.start must be integer"},
+		{name: "null", args: `{"file":null,"start":1}`, wantErr: "arguments.file must be string, not null"},
+		{name: "enum", args: `{"file":"a.go","start":1,"kind":"var"}`, wantErr: "arguments.kind must be one of"},
+		{name: "array item", args: `{"file":"a.go","start":1,"names":["A",2]}`, wantErr: "arguments.names[1] must be string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := def.ValidateArgs(tt.args)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateArgs() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateArgs() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	noSchema := ToolDef{FunctionDefinition: openai.FunctionDefinition{Name: "raw", Parameters: map[string]any{"type": "object"}}}
+	if err := noSchema.ValidateArgs(`{"any":1}`); err != nil {
+		t.Errorf("tools without jsonschema.Definition are not validated, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"llm_dev/agent"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultModelName is the model name reported when the request names none.
+const DefaultModelName = "llm_dev"
+
+// CompletionAgent is what the chat completion endpoint needs from agent.BaseAgent.
+type CompletionAgent interface {
+	RunConversation(taskCtx context.Context, messages []openai.ChatCompletionMessage) (agent.TaskOutcome, error)
+	SetEventHandler(handler agent.EventHandler)
+	Close() error
+}
+
+var _ CompletionAgent = (*agent.BaseAgent)(nil)
+
+// CompletionAgentFactory creates the agent answering one chat completion request, it is closed after
+// the request, so the requests share nothing and run concurrently.
+type CompletionAgentFactory func() (CompletionAgent, error)
+
+// EnableCompletions serves the OpenAI compatible chat completion endpoint, the conversation of every
+// request is answered by a new agent running the tools on the server.
+func (srv *Server) EnableCompletions(newAgent CompletionAgentFactory) {
+	srv.newCompletionAgent = newAgent
+}
+
+type modelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (srv *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	if srv.newCompletionAgent == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found_error", errors.New("chat completions are not enabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   []modelInfo{{ID: DefaultModelName, Object: "model", OwnedBy: DefaultModelName}},
+	})
+}
+
+// chatCompletions answers the conversation with the agent, the client tools and several choices are not
+// supported. The streamed content is the text of every response of the task, so it also holds what the
+// model writes before its tool calls, the non-streamed content is the final answer only.
+// Disconnecting interrupts the task.
+func (srv *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if srv.newCompletionAgent == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found_error", errors.New("chat completions are not enabled"))
+		return
+	}
+	req := openai.ChatCompletionRequest{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.Tools) != 0 || len(req.Functions) != 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", errors.New("tools are not supported, the agent runs its own codebase tools"))
+		return
+	}
+	if req.N > 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", errors.New("only one choice is supported"))
+		return
+	}
+	a, err := srv.newCompletionAgent()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+	defer a.Close()
+
+	completion := newCompletion(req.Model)
+	if req.Stream {
+		srv.streamCompletion(w, r, a, req, completion)
+		return
+	}
+	outcome, err := a.RunConversation(r.Context(), req.Messages)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err)
+		return
+	}
+	if outcome.Status == agent.OutcomeError {
+		writeAPIError(w, http.StatusBadGateway, "upstream_error", outcomeError(outcome))
+		return
+	}
+	writeJSON(w, http.StatusOK, openai.ChatCompletionResponse{
+		ID:      completion.id,
+		Object:  "chat.completion",
+		Created: completion.created,
+		Model:   completion.model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: outcome.Answer},
+			FinishReason: finishReason(outcome),
+		}},
+		Usage: completionUsage(outcome.Usage),
+	})
+}
+
+// streamCompletion streams the text deltas of the task as chat completion chunks. The agent calls the
+// event handler on the goroutine of the request, so the chunks are written directly.
+func (srv *Server) streamCompletion(w http.ResponseWriter, r *http.Request, a CompletionAgent, req openai.ChatCompletionRequest, completion completion) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "server_error", errors.New("streaming is not supported"))
+		return
+	}
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		completion.writeChunk(w, openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "", nil)
+	}
+	// the responses of the turns are separated by an empty line
+	turnText, separate := false, false
+	a.SetEventHandler(func(event agent.Event) {
+		switch event := event.(type) {
+		case agent.TurnStartedEvent:
+			separate = separate || turnText
+			turnText = false
+		case agent.ResponseDiscardedEvent:
+			// the sent text can not be withdrawn, the retried response starts after an empty line
+			separate = separate || turnText
+			turnText = false
+		case agent.TextDeltaEvent:
+			if event.Text == "" {
+				return
+			}
+			start()
+			text := event.Text
+			if separate {
+				text = "\n\n" + text
+				separate = false
+			}
+			turnText = true
+			completion.writeChunk(w, openai.ChatCompletionStreamChoiceDelta{Content: text}, "", nil)
+			flusher.Flush()
+		}
+	})
+	outcome, err := a.RunConversation(r.Context(), req.Messages)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err)
+		return
+	}
+	if outcome.Status == agent.OutcomeError && !started {
+		writeAPIError(w, http.StatusBadGateway, "upstream_error", outcomeError(outcome))
+		return
+	}
+	start()
+	if outcome.Status == agent.OutcomeError {
+		// the error of a started stream is sent as a data line, as the OpenAI API does
+		data, _ := json.Marshal(apiError(outcomeError(outcome), "upstream_error"))
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		return
+	}
+	completion.writeChunk(w, openai.ChatCompletionStreamChoiceDelta{}, finishReason(outcome), nil)
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		usage := completionUsage(outcome.Usage)
+		completion.writeChunk(w, openai.ChatCompletionStreamChoiceDelta{}, "", &usage)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// completion holds the fields shared by the chunks of a completion.
+type completion struct {
+	id      string
+	created int64
+	model   string
+}
+
+func newCompletion(model string) completion {
+	if model == "" {
+		model = DefaultModelName
+	}
+	return completion{
+		id:      fmt.Sprintf("chatcmpl-%016x", rand.Uint64()),
+		created: time.Now().Unix(),
+		model:   model,
+	}
+}
+
+// writeChunk writes a chunk with the delta, the usage chunk has no choice.
+func (c completion) writeChunk(w http.ResponseWriter, delta openai.ChatCompletionStreamChoiceDelta, reason openai.FinishReason, usage *openai.Usage) {
+	chunk := openai.ChatCompletionStreamResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: reason}},
+		Usage:   usage,
+	}
+	if usage != nil {
+		chunk.Choices = []openai.ChatCompletionStreamChoice{}
+	}
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		log.Error().Err(err).Msg("marshal chunk fail")
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// finishReason tells the client if the answer is complete, a task stopped early has a partial answer.
+func finishReason(outcome agent.TaskOutcome) openai.FinishReason {
+	if outcome.Status == agent.OutcomeCompleted {
+		return openai.FinishReasonStop
+	}
+	return openai.FinishReasonLength
+}
+
+func completionUsage(usage agent.TokenUsage) openai.Usage {
+	return openai.Usage{
+		PromptTokens:        usage.PromptTokens,
+		CompletionTokens:    usage.CompletionTokens,
+		TotalTokens:         usage.PromptTokens + usage.CompletionTokens,
+		PromptTokensDetails: &openai.PromptTokensDetails{CachedTokens: usage.CachedTokens},
+	}
+}
+
+func outcomeError(outcome agent.TaskOutcome) error {
+	if outcome.Err != nil {
+		return fmt.Errorf("%s: %w", outcome.Reason, outcome.Err)
+	}
+	return errors.New(outcome.Reason)
+}
+
+func apiError(err error, errType string) map[string]any {
+	return map[string]any{"error": map[string]string{"message": err.Error(), "type": errType}}
+}
+
+// writeAPIError writes the error in the format of the OpenAI API, so the clients report the message.
+func writeAPIError(w http.ResponseWriter, status int, errType string, err error) {
+	writeJSON(w, status, apiError(err, errType))
+}
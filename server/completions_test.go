@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"llm_dev/agent"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeCompletionAgent answers with two responses, the first one before a tool call and the second one
+// after a failed attempt, a prompt "fail" fails the task and a prompt "invalid" is rejected.
+type fakeCompletionAgent struct {
+	emit   agent.EventHandler
+	closed *atomic.Int32
+}
+
+func (a *fakeCompletionAgent) RunConversation(taskCtx context.Context, messages []openai.ChatCompletionMessage) (agent.TaskOutcome, error) {
+	prompt := messages[len(messages)-1].Content
+	if prompt == "invalid" {
+		return agent.TaskOutcome{}, errors.New("the conversation must end with a user message")
+	}
+	if prompt == "fail" {
+		return agent.TaskOutcome{Status: agent.OutcomeError, Reason: "chat completion failed", Err: errors.New("503")}, nil
+	}
+	a.emit(agent.TurnStartedEvent{Turn: 1})
+	a.emit(agent.TextDeltaEvent{Text: "let me look"})
+	a.emit(agent.TurnStartedEvent{Turn: 2})
+	a.emit(agent.TextDeltaEvent{Text: "ech"})
+	a.emit(agent.ResponseDiscardedEvent{Text: "ech"})
+	a.emit(agent.TextDeltaEvent{Text: "echo "})
+	a.emit(agent.TextDeltaEvent{Text: prompt})
+	return agent.TaskOutcome{
+		Status: agent.OutcomeCompleted,
+		Answer: "echo " + prompt,
+		Usage:  agent.TokenUsage{Requests: 2, PromptTokens: 100, CachedTokens: 40, CompletionTokens: 10},
+	}, nil
+}
+
+func (a *fakeCompletionAgent) SetEventHandler(handler agent.EventHandler) { a.emit = handler }
+func (a *fakeCompletionAgent) Close() error                               { a.closed.Add(1); return nil }
+
+func newCompletionClient(t *testing.T) (*openai.Client, *atomic.Int32) {
+	t.Helper()
+	closed := &atomic.Int32{}
+	srv := New(nil)
+	srv.EnableCompletions(func() (CompletionAgent, error) {
+		return &fakeCompletionAgent{emit: func(agent.Event) {}, closed: closed}, nil
+	})
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(httpServer.Close)
+	cfg := openai.DefaultConfig("unused")
+	cfg.BaseURL = httpServer.URL + "/v1"
+	return openai.NewClientWithConfig(cfg), closed
+}
+
+func userMessage(content string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: content}}
+}
+
+func TestServer_chatCompletion(t *testing.T) {
+	client, closed := newCompletionClient(t)
+	resp, err := client.CreateChatCompletion(t.Context(), openai.ChatCompletionRequest{Messages: userMessage("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != DefaultModelName || len(resp.Choices) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	choice := resp.Choices[0]
+	if choice.Message.Content != "echo hello" || choice.FinishReason != openai.FinishReasonStop {
+		t.Errorf("choice = %+v", choice)
+	}
+	if resp.Usage.TotalTokens != 110 || resp.Usage.PromptTokensDetails.CachedTokens != 40 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	if closed.Load() != 1 {
+		t.Errorf("%d agents closed, want 1", closed.Load())
+	}
+
+	tests := map[string]struct {
+		req    openai.ChatCompletionRequest
+		status int
+	}{
+		"invalid conversation": {openai.ChatCompletionRequest{Messages: userMessage("invalid")}, http.StatusBadRequest},
+		"task failed":          {openai.ChatCompletionRequest{Messages: userMessage("fail")}, http.StatusBadGateway},
+		"client tools": {openai.ChatCompletionRequest{
+			Messages: userMessage("hi"),
+			Tools:    []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "run"}}},
+		}, http.StatusBadRequest},
+		"several choices": {openai.ChatCompletionRequest{Messages: userMessage("hi"), N: 2}, http.StatusBadRequest},
+	}
+	for name, test := range tests {
+		_, err := client.CreateChatCompletion(t.Context(), test.req)
+		apiErr := &openai.APIError{}
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != test.status || apiErr.Message == "" {
+			t.Errorf("%s: error = %v, want status %d", name, err, test.status)
+		}
+	}
+}
+
+func TestServer_chatCompletionStream(t *testing.T) {
+	client, _ := newCompletionClient(t)
+	stream, err := client.CreateChatCompletionStream(t.Context(), openai.ChatCompletionRequest{
+		Model:         "codebase",
+		Messages:      userMessage("hello"),
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var content strings.Builder
+	var reason openai.FinishReason
+	var usage *openai.Usage
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Model != "codebase" {
+			t.Errorf("chunk model = %s", chunk.Model)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				reason = choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	// the text of the discarded attempt is already sent, the retried response follows it
+	if content.String() != "let me look\n\nech\n\necho hello" || reason != openai.FinishReasonStop {
+		t.Errorf("content = %q, finish reason = %s", content.String(), reason)
+	}
+	if usage == nil || usage.TotalTokens != 110 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	_, err = client.CreateChatCompletionStream(t.Context(), openai.ChatCompletionRequest{Messages: userMessage("fail"), Stream: true})
+	apiErr := &openai.APIError{}
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusBadGateway {
+		t.Errorf("error = %v, want status %d", err, http.StatusBadGateway)
+	}
+}
+
+func TestServer_completionsDisabled(t *testing.T) {
+	httpServer := httptest.NewServer(New(nil).Handler())
+	defer httpServer.Close()
+	doJSON(t, "POST", httpServer.URL+"/v1/chat/completions", `{"messages":[]}`, http.StatusNotFound, nil)
+	doJSON(t, "GET", httpServer.URL+"/v1/models", "", http.StatusNotFound, nil)
+}
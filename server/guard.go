@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AllowHost accepts the requests sent to host besides the loopback names, it is needed when the server
+// listens on a non-loopback address on purpose.
+func (srv *Server) AllowHost(host string) {
+	srv.allowedHosts = append(srv.allowedHosts, strings.ToLower(host))
+}
+
+// guard rejects the requests a web page can send to the local server: the requests for another host,
+// which come from DNS rebinding, the requests from another origin and the POST requests without a JSON
+// body, which a page can send without a CORS preflight.
+func (srv *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.checkRequest(r); err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, errContentType) {
+				status = http.StatusUnsupportedMediaType
+			}
+			if strings.HasPrefix(r.URL.Path, "/v1/") {
+				writeAPIError(w, status, "invalid_request_error", err)
+			} else {
+				writeError(w, status, err)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errContentType = errors.New("the request body must be application/json")
+
+func (srv *Server) checkRequest(r *http.Request) error {
+	if !srv.allowedHost(hostname(r.Host)) {
+		return fmt.Errorf("host %s is not allowed", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || !strings.EqualFold(u.Host, r.Host) {
+			return fmt.Errorf("origin %s is not allowed", origin)
+		}
+	}
+	if r.Method == http.MethodPost {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return errContentType
+		}
+	}
+	return nil
+}
+
+func (srv *Server) allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, allowed := range srv.allowedHosts {
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// hostname strips the port of the Host header.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
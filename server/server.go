@@ -0,0 +1,370 @@
+// Package server serves the agent over HTTP, every session has its own agent and the events of the
+// tasks are streamed with server-sent events.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"llm_dev/agent"
+	llmctx "llm_dev/context"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultAddr only accepts connections from the local machine, the agent reads and edits the codebase.
+const DefaultAddr = "127.0.0.1:8765"
+
+const (
+	maxRequestBody = 1 << 20
+	keepAlive      = 15 * time.Second
+	closeTimeout   = 30 * time.Second
+)
+
+// AgentFactory creates the agent of a new session, it resumes the saved session if resume is not empty,
+// otherwise it starts a new session. The id of the agent session identifies the session on the server.
+type AgentFactory func(resume string) (Agent, error)
+
+type Server struct {
+	newAgent AgentFactory
+	// newCompletionAgent is nil if the chat completion endpoint is not enabled.
+	newCompletionAgent CompletionAgentFactory
+
+	// allowedHosts are the hosts accepted besides the loopback names, see AllowHost.
+	allowedHosts []string
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// resuming are the ids of the saved sessions being resumed, a session is resumed once.
+	resuming map[string]struct{}
+}
+
+func New(newAgent AgentFactory) *Server {
+	return &Server{newAgent: newAgent, sessions: make(map[string]*session), resuming: make(map[string]struct{})}
+}
+
+// IsLoopback tells if the listen address only accepts local connections.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Handler returns the HTTP API:
+//
+//	POST   /sessions                 create a session, {"resume": id, "mode": mode} are optional
+//	GET    /sessions                 list the sessions
+//	GET    /sessions/{id}            get the session
+//	DELETE /sessions/{id}            interrupt the running task and close the session
+//	POST   /sessions/{id}/prompts    run {"prompt": text, "mode": mode}, wait for the outcome if "wait" is true
+//	POST   /sessions/{id}/interrupt  interrupt the running task
+//	GET    /sessions/{id}/history    the summaries of the finished tasks
+//	GET    /sessions/{id}/events     the events of the tasks as server-sent events
+//	POST   /v1/chat/completions      the OpenAI compatible chat completion, see EnableCompletions
+//	GET    /v1/models                the model of the chat completion
+//
+// The requests must be sent to a loopback host or an allowed host, from no origin or the same origin,
+// and the POST requests must be application/json.
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", srv.createSession)
+	mux.HandleFunc("GET /sessions", srv.listSessions)
+	mux.HandleFunc("GET /sessions/{id}", srv.withSession(srv.getSession))
+	mux.HandleFunc("DELETE /sessions/{id}", srv.withSession(srv.deleteSession))
+	mux.HandleFunc("POST /sessions/{id}/prompts", srv.withSession(srv.sendPrompt))
+	mux.HandleFunc("POST /sessions/{id}/interrupt", srv.withSession(srv.interrupt))
+	mux.HandleFunc("GET /sessions/{id}/history", srv.withSession(srv.history))
+	mux.HandleFunc("GET /sessions/{id}/events", srv.withSession(srv.events))
+	mux.HandleFunc("POST /v1/chat/completions", srv.chatCompletions)
+	mux.HandleFunc("GET /v1/models", srv.listModels)
+	return srv.guard(mux)
+}
+
+// Close closes every session, the running tasks are interrupted.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	sessions := slices.Collect(maps.Values(srv.sessions))
+	srv.sessions = make(map[string]*session)
+	srv.mu.Unlock()
+	errs := []error{}
+	for _, s := range sessions {
+		if err := s.close(closeTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("close session %s: %w", s.id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type sessionInfo struct {
+	ID      string             `json:"id"`
+	Root    string             `json:"root"`
+	Title   string             `json:"title,omitempty"`
+	Mode    llmctx.Mode        `json:"mode,omitempty"`
+	Running bool               `json:"running"`
+	Last    *agent.TaskOutcome `json:"last_outcome,omitempty"`
+	Tasks   int                `json:"tasks"`
+}
+
+// info describes the session, the fields read from the agent are left empty while a task is running.
+func (s *session) info() sessionInfo {
+	info := sessionInfo{ID: s.id}
+	info.Running, info.Last = s.state()
+	s.withAgent(func(a Agent) error {
+		session := a.Session()
+		info.Root = session.Root
+		info.Title = session.Title
+		info.Mode = a.Mode()
+		info.Tasks = len(a.History())
+		return nil
+	})
+	return info
+}
+
+type createRequest struct {
+	Resume string      `json:"resume"`
+	Mode   llmctx.Mode `json:"mode"`
+}
+
+func (srv *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	req := createRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Mode != "" {
+		if _, err := llmctx.ParseMode(string(req.Mode)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.Resume != "" {
+		if !srv.reserve(req.Resume) {
+			writeError(w, http.StatusConflict, fmt.Errorf("session %s is already open", req.Resume))
+			return
+		}
+		defer srv.release(req.Resume)
+	}
+	a, err := srv.newAgent(req.Resume)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Mode != "" {
+		if err := a.SetMode(req.Mode); err != nil {
+			a.Close()
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	s := newSession(a)
+	srv.mu.Lock()
+	srv.sessions[s.id] = s
+	srv.mu.Unlock()
+	log.Info().Str("session", s.id).Msg("session created")
+	writeJSON(w, http.StatusCreated, s.info())
+}
+
+// reserve marks the saved session as being resumed, it returns false if the session is open or being
+// resumed by another request.
+func (srv *Server) reserve(id string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	_, open := srv.sessions[id]
+	_, resuming := srv.resuming[id]
+	if open || resuming {
+		return false
+	}
+	srv.resuming[id] = struct{}{}
+	return true
+}
+
+func (srv *Server) release(id string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.resuming, id)
+}
+
+func (srv *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	ids := slices.Sorted(maps.Keys(srv.sessions))
+	sessions := make([]*session, len(ids))
+	for i, id := range ids {
+		sessions[i] = srv.sessions[id]
+	}
+	srv.mu.Unlock()
+	res := []sessionInfo{}
+	for _, s := range sessions {
+		res = append(res, s.info())
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (srv *Server) withSession(handler func(w http.ResponseWriter, r *http.Request, s *session)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		srv.mu.Lock()
+		s, exist := srv.sessions[id]
+		srv.mu.Unlock()
+		if !exist {
+			writeError(w, http.StatusNotFound, fmt.Errorf("session %s not found", id))
+			return
+		}
+		handler(w, r, s)
+	}
+}
+
+func (srv *Server) getSession(w http.ResponseWriter, r *http.Request, s *session) {
+	writeJSON(w, http.StatusOK, s.info())
+}
+
+func (srv *Server) deleteSession(w http.ResponseWriter, r *http.Request, s *session) {
+	srv.mu.Lock()
+	delete(srv.sessions, s.id)
+	srv.mu.Unlock()
+	if err := s.close(closeTimeout); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type promptRequest struct {
+	Prompt string      `json:"prompt"`
+	Mode   llmctx.Mode `json:"mode"`
+	// Wait makes the request return the task outcome when the task ends.
+	Wait bool `json:"wait"`
+}
+
+func (srv *Server) sendPrompt(w http.ResponseWriter, r *http.Request, s *session) {
+	req := promptRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Prompt == "" {
+		writeError(w, http.StatusBadRequest, errors.New("prompt is empty"))
+		return
+	}
+	if req.Mode != "" {
+		if _, err := llmctx.ParseMode(string(req.Mode)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	done, err := s.start(req.Prompt, req.Mode)
+	if errors.Is(err, errBusy) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !req.Wait {
+		writeJSON(w, http.StatusAccepted, s.info())
+		return
+	}
+	select {
+	case <-done:
+		_, last := s.state()
+		writeJSON(w, http.StatusOK, last)
+	case <-r.Context().Done():
+		// the client is gone, the task keeps running and can be followed by the events
+	}
+}
+
+func (srv *Server) interrupt(w http.ResponseWriter, r *http.Request, s *session) {
+	if !s.interrupt() {
+		writeError(w, http.StatusConflict, errors.New("no task is running"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (srv *Server) history(w http.ResponseWriter, r *http.Request, s *session) {
+	var history []agent.TaskMemory
+	err := s.withAgent(func(a Agent) error {
+		history = a.History()
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if history == nil {
+		history = []agent.TaskMemory{}
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// events streams the events of the session until the client disconnects, every event is sent with
+// its type as the event name and its JSON as the data.
+func (srv *Server) events(w http.ResponseWriter, r *http.Request, s *session) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error().Err(err).Str("event", string(event.EventType())).Msg("marshal event fail")
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.EventType(), data)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// readJSON decodes the request body into v, an empty body keeps v as is.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("write response fail")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
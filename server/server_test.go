@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"llm_dev/agent"
+	llmctx "llm_dev/context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAgent answers every prompt with the prompt, a prompt starting with "block" runs until it is canceled
+// and a prompt starting with "stuck" runs until release is closed.
+type fakeAgent struct {
+	release chan struct{}
+	session *agent.Session
+	mode    llmctx.Mode
+	history []agent.TaskMemory
+	emit    agent.EventHandler
+	closed  atomic.Bool
+}
+
+func (a *fakeAgent) NewUserTask(taskCtx context.Context, userprompt string) agent.TaskOutcome {
+	a.emit(agent.TurnStartedEvent{Turn: 1})
+	outcome := agent.TaskOutcome{Status: agent.OutcomeCompleted, Answer: "echo " + userprompt, Iterations: 1}
+	if strings.HasPrefix(userprompt, "stuck") {
+		<-a.release
+		outcome = agent.TaskOutcome{Status: agent.OutcomeAborted, Reason: "interrupted by the user", Iterations: 1}
+	} else if strings.HasPrefix(userprompt, "block") {
+		<-taskCtx.Done()
+		outcome = agent.TaskOutcome{Status: agent.OutcomeAborted, Reason: "interrupted by the user", Iterations: 1}
+	} else {
+		a.emit(agent.TextDeltaEvent{Text: outcome.Answer})
+	}
+	a.history = append(a.history, agent.TaskMemory{Prompt: userprompt, Summary: outcome.Answer})
+	a.emit(agent.TaskFinishedEvent{Outcome: outcome})
+	return outcome
+}
+
+func (a *fakeAgent) SetEventHandler(handler agent.EventHandler) { a.emit = handler }
+func (a *fakeAgent) SetMode(mode llmctx.Mode) error {
+	if _, err := llmctx.ParseMode(string(mode)); err != nil {
+		return err
+	}
+	a.mode = mode
+	return nil
+}
+func (a *fakeAgent) Mode() llmctx.Mode           { return a.mode }
+func (a *fakeAgent) History() []agent.TaskMemory { return a.history }
+func (a *fakeAgent) Session() *agent.Session     { return a.session }
+func (a *fakeAgent) Close() error                { a.closed.Store(true); return nil }
+
+func newTestServer(t *testing.T) (*httptest.Server, map[string]*fakeAgent) {
+	t.Helper()
+	agents := map[string]*fakeAgent{}
+	count := 0
+	srv := New(func(resume string) (Agent, error) {
+		if resume == "missing" {
+			return nil, fmt.Errorf("session %s not found", resume)
+		}
+		count++
+		id := resume
+		if id == "" {
+			id = fmt.Sprintf("s%d", count)
+		}
+		a := &fakeAgent{session: &agent.Session{ID: id, Root: "/code"}, mode: llmctx.ModeAsk}
+		agents[id] = a
+		return a, nil
+	})
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		httpServer.Close()
+	})
+	return httpServer, agents
+}
+
+func doJSON(t *testing.T, method string, url string, body string, wantStatus int, res any) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		var errBody map[string]string
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		t.Fatalf("%s %s = %d %v, want %d", method, url, resp.StatusCode, errBody, wantStatus)
+	}
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServer_sessions(t *testing.T) {
+	httpServer, agents := newTestServer(t)
+	url := httpServer.URL
+
+	info := sessionInfo{}
+	doJSON(t, "POST", url+"/sessions", `{"mode":"plan"}`, http.StatusCreated, &info)
+	if info.ID != "s1" || info.Root != "/code" || info.Mode != llmctx.ModePlan {
+		t.Errorf("created session = %+v", info)
+	}
+	doJSON(t, "POST", url+"/sessions", ``, http.StatusCreated, nil)
+	doJSON(t, "POST", url+"/sessions", `{"resume":"saved"}`, http.StatusCreated, nil)
+	doJSON(t, "POST", url+"/sessions", `{"resume":"saved"}`, http.StatusConflict, nil)
+	doJSON(t, "POST", url+"/sessions", `{"resume":"missing"}`, http.StatusBadRequest, nil)
+	doJSON(t, "POST", url+"/sessions", `{"mode":"yolo"}`, http.StatusBadRequest, nil)
+	if len(agents) != 3 {
+		t.Errorf("%d agents created, an unknown mode must be rejected before the agent is created", len(agents))
+	}
+
+	list := []sessionInfo{}
+	doJSON(t, "GET", url+"/sessions", "", http.StatusOK, &list)
+	ids := []string{}
+	for _, s := range list {
+		ids = append(ids, s.ID)
+	}
+	if want := []string{"s1", "s2", "saved"}; !slices.Equal(ids, want) {
+		t.Errorf("sessions = %v, want %v", ids, want)
+	}
+
+	outcome := agent.TaskOutcome{}
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":"hello","mode":"edit","wait":true}`, http.StatusOK, &outcome)
+	if outcome.Status != agent.OutcomeCompleted || outcome.Answer != "echo hello" {
+		t.Errorf("outcome = %+v", outcome)
+	}
+	if agents["s1"].mode != llmctx.ModeEdit {
+		t.Errorf("mode = %s, want edit", agents["s1"].mode)
+	}
+	history := []agent.TaskMemory{}
+	doJSON(t, "GET", url+"/sessions/s1/history", "", http.StatusOK, &history)
+	if len(history) != 1 || history[0].Prompt != "hello" {
+		t.Errorf("history = %+v", history)
+	}
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":""}`, http.StatusBadRequest, nil)
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":"hi","mode":"yolo"}`, http.StatusBadRequest, nil)
+	doJSON(t, "GET", url+"/sessions/none/history", "", http.StatusNotFound, nil)
+
+	doJSON(t, "DELETE", url+"/sessions/s2", "", http.StatusNoContent, nil)
+	if !agents["s2"].closed.Load() {
+		t.Error("the deleted session agent is not closed")
+	}
+	doJSON(t, "GET", url+"/sessions/s2", "", http.StatusNotFound, nil)
+}
+
+func TestServer_concurrentResume(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	srv := New(func(resume string) (Agent, error) {
+		if resume == "missing" {
+			return nil, fmt.Errorf("session %s not found", resume)
+		}
+		close(entered)
+		<-release
+		return &fakeAgent{session: &agent.Session{ID: resume}, mode: llmctx.ModeAsk}, nil
+	})
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		httpServer.Close()
+	})
+	url := httpServer.URL
+
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		doJSON(t, "POST", url+"/sessions", `{"resume":"saved"}`, http.StatusCreated, nil)
+	}()
+	<-entered
+	doJSON(t, "POST", url+"/sessions", `{"resume":"saved"}`, http.StatusConflict, nil)
+	close(release)
+	<-first
+	doJSON(t, "POST", url+"/sessions", `{"resume":"saved"}`, http.StatusConflict, nil)
+
+	// a failed resume releases the id
+	doJSON(t, "POST", url+"/sessions", `{"resume":"missing"}`, http.StatusBadRequest, nil)
+	doJSON(t, "POST", url+"/sessions", `{"resume":"missing"}`, http.StatusBadRequest, nil)
+}
+
+func TestSession_closeTimeout(t *testing.T) {
+	a := &fakeAgent{release: make(chan struct{}), session: &agent.Session{ID: "s1"}, mode: llmctx.ModeAsk}
+	s := newSession(a)
+	done, err := s.start("stuck on the task", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(10 * time.Millisecond); err == nil {
+		t.Fatal("close() expects an error while the task does not stop")
+	}
+	if a.closed.Load() {
+		t.Fatal("the agent is closed while the task is running")
+	}
+	close(a.release)
+	<-done
+	// the agent is closed and the session lock released once the task returns
+	for deadline := time.Now().Add(5 * time.Second); !a.closed.Load() || !s.mu.TryLock(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the agent is not closed after the task returned")
+		}
+	}
+}
+
+func TestServer_runningTask(t *testing.T) {
+	httpServer, _ := newTestServer(t)
+	url := httpServer.URL
+	doJSON(t, "POST", url+"/sessions", ``, http.StatusCreated, nil)
+	doJSON(t, "POST", url+"/sessions/s1/interrupt", "", http.StatusConflict, nil)
+
+	info := sessionInfo{}
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":"block"}`, http.StatusAccepted, &info)
+	if !info.Running {
+		t.Errorf("session = %+v, want running", info)
+	}
+	// one task at a time, the agent is not touched while it runs
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":"hi"}`, http.StatusConflict, nil)
+	doJSON(t, "GET", url+"/sessions/s1/history", "", http.StatusConflict, nil)
+	doJSON(t, "POST", url+"/sessions/s1/interrupt", "", http.StatusAccepted, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for info.Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		doJSON(t, "GET", url+"/sessions/s1", "", http.StatusOK, &info)
+	}
+	if info.Running || info.Last == nil || info.Last.Status != agent.OutcomeAborted {
+		t.Errorf("session = %+v, want the interrupted task", info)
+	}
+}
+
+func TestServer_events(t *testing.T) {
+	httpServer, _ := newTestServer(t)
+	url := httpServer.URL
+	doJSON(t, "POST", url+"/sessions", ``, http.StatusCreated, nil)
+
+	resp, err := http.Get(url + "/sessions/s1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %s", ct)
+	}
+	doJSON(t, "POST", url+"/sessions/s1/prompts", `{"prompt":"hello"}`, http.StatusAccepted, nil)
+
+	scanner := bufio.NewScanner(resp.Body)
+	names := []string{}
+	data := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+		if d, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, d)
+		}
+		if len(data) == 3 {
+			break
+		}
+	}
+	want := []string{"turn_started", "text_delta", "task_finished"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+	if data[1] != `{"text":"echo hello"}` {
+		t.Errorf("text delta data = %s", data[1])
+	}
+	finished := agent.TaskFinishedEvent{}
+	if err := json.Unmarshal([]byte(data[2]), &finished); err != nil || finished.Outcome.Status != agent.OutcomeCompleted {
+		t.Errorf("task finished data = %s, %v", data[2], err)
+	}
+}
+
+func TestServer_guard(t *testing.T) {
+	httpServer, agents := newTestServer(t)
+	tests := []struct {
+		name        string
+		host        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{name: "loopback", contentType: "application/json", status: http.StatusCreated},
+		{name: "localhost", host: "localhost:8765", contentType: "application/json; charset=utf-8", status: http.StatusCreated},
+		{name: "same origin", origin: httpServer.URL, contentType: "application/json", status: http.StatusCreated},
+		{name: "simple request", contentType: "text/plain", status: http.StatusUnsupportedMediaType},
+		{name: "no content type", status: http.StatusUnsupportedMediaType},
+		{name: "dns rebinding", host: "attacker.example:8765", contentType: "application/json", status: http.StatusForbidden},
+		{name: "cross origin", origin: "http://attacker.example", contentType: "application/json", status: http.StatusForbidden},
+		{name: "null origin", origin: "null", contentType: "application/json", status: http.StatusForbidden},
+	}
+	created := 0
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", httpServer.URL+"/sessions", strings.NewReader(`{"mode":"edit"}`))
+		if test.host != "" {
+			req.Host = test.host
+		}
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, resp.StatusCode, test.status)
+		}
+		if resp.StatusCode == http.StatusCreated {
+			created++
+		}
+	}
+	if len(agents) != created {
+		t.Errorf("%d agents created by %d accepted requests", len(agents), created)
+	}
+
+	srv := New(nil)
+	srv.AllowHost("devbox.lan")
+	if !srv.allowedHost("DEVBOX.lan") || !srv.allowedHost("::1") || srv.allowedHost("other.lan") {
+		t.Error("allowed hosts are not applied")
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8765": true,
+		"localhost:80":   true,
+		"[::1]:8765":     true,
+		"0.0.0.0:8765":   false,
+		":8765":          false,
+		"10.0.0.2:8765":  false,
+		"nonsense":       false,
+	}
+	for addr, want := range tests {
+		if got := IsLoopback(addr); got != want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"llm_dev/agent"
+	llmctx "llm_dev/context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Agent is what the server needs from agent.BaseAgent.
+type Agent interface {
+	NewUserTask(taskCtx context.Context, userprompt string) agent.TaskOutcome
+	SetEventHandler(handler agent.EventHandler)
+	SetMode(mode llmctx.Mode) error
+	Mode() llmctx.Mode
+	History() []agent.TaskMemory
+	Session() *agent.Session
+	Close() error
+}
+
+var _ Agent = (*agent.BaseAgent)(nil)
+
+// subscriberBuffer is the number of events kept for a slow subscriber before it is dropped.
+const subscriberBuffer = 1024
+
+var errBusy = errors.New("the session is running a task")
+
+// session is an agent served over HTTP, it runs one task at a time and fans the events of the
+// tasks out to the subscribers.
+type session struct {
+	id    string
+	agent Agent
+
+	// mu is held by the running task, the other requests to the agent fail while it is held.
+	mu sync.Mutex
+
+	stateMu sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	last    *agent.TaskOutcome
+
+	subMu       sync.Mutex
+	subscribers map[chan agent.Event]struct{}
+	closed      bool
+}
+
+func newSession(a Agent) *session {
+	s := &session{
+		id:          a.Session().ID,
+		agent:       a,
+		subscribers: make(map[chan agent.Event]struct{}),
+	}
+	a.SetEventHandler(s.publish)
+	return s
+}
+
+// publish sends the event to every subscriber, a subscriber not keeping up is dropped so the task
+// is never blocked, it can subscribe again and read the history.
+func (s *session) publish(event agent.Event) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warn().Str("session", s.id).Msg("drop slow event subscriber")
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the channel receiving the events, it is closed when the subscriber is dropped
+// or the session is closed.
+func (s *session) subscribe() chan agent.Event {
+	ch := make(chan agent.Event, subscriberBuffer)
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if s.closed {
+		close(ch)
+		return ch
+	}
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (s *session) unsubscribe(ch chan agent.Event) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if _, exist := s.subscribers[ch]; exist {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// start runs the prompt as a new task in the background, done is closed when the task ends.
+func (s *session) start(prompt string, mode llmctx.Mode) (done chan struct{}, err error) {
+	if !s.mu.TryLock() {
+		return nil, errBusy
+	}
+	if mode != "" {
+		if err := s.agent.SetMode(mode); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	taskCtx, cancel := context.WithCancel(context.Background())
+	s.stateMu.Lock()
+	s.running = true
+	s.cancel = cancel
+	s.stateMu.Unlock()
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		defer s.mu.Unlock()
+		outcome := s.agent.NewUserTask(taskCtx, prompt)
+		cancel()
+		s.stateMu.Lock()
+		s.running = false
+		s.cancel = nil
+		s.last = &outcome
+		s.stateMu.Unlock()
+	}()
+	return done, nil
+}
+
+// state returns if a task is running and the outcome of the last finished task.
+func (s *session) state() (running bool, last *agent.TaskOutcome) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.running, s.last
+}
+
+// interrupt cancels the running task, it returns false if no task is running.
+func (s *session) interrupt() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return s.running
+}
+
+// withAgent runs fn with the agent if no task is running.
+func (s *session) withAgent(fn func(a Agent) error) error {
+	if !s.mu.TryLock() {
+		return errBusy
+	}
+	defer s.mu.Unlock()
+	return fn(s.agent)
+}
+
+// close interrupts the running task, ends the event streams and closes the agent once the task returns.
+// If the task does not return in time close returns an error, the agent is still closed, and its session
+// saved, when the task returns.
+func (s *session) close(timeout time.Duration) error {
+	s.interrupt()
+	s.subMu.Lock()
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+	s.subMu.Unlock()
+	closed := make(chan error, 1)
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		closed <- s.agent.Close()
+	}()
+	select {
+	case err := <-closed:
+		return err
+	case <-time.After(timeout):
+		go func() {
+			if err := <-closed; err != nil {
+				log.Error().Err(err).Str("session", s.id).Msg("close session fail")
+				return
+			}
+			log.Info().Str("session", s.id).Msg("session closed after the task returned")
+		}()
+		return errors.New("the running task did not stop in time, the session is closed when it returns")
+	}
+}
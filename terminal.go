@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"llm_dev/agent"
+	"os"
+)
+
+// terminalEvents prints the agent events for the REPL and appends the responses, the tool results
+// and the rendered context to the log file at logPath.
+func terminalEvents(out io.Writer, logPath string) agent.EventHandler {
+	logEvent := func(format string, args ...any) {
+		file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		fmt.Fprintf(file, format, args...)
+	}
+	return func(event agent.Event) {
+		switch e := event.(type) {
+		case agent.ContextRenderedEvent:
+			fmt.Fprintf(out, "CONTEXT USAGE: %s\n", e.Usage)
+			logEvent("CONTEXT:\n%s", e.Context)
+			fmt.Fprint(out, "RESP:\n")
+		case agent.TextDeltaEvent:
+			fmt.Fprint(out, e.Text)
+		case agent.ResponseDiscardedEvent:
+			fmt.Fprint(out, "\nRESP DISCARDED, the response above is incomplete and requested again\n")
+		case agent.ResponseFinishedEvent:
+			fmt.Fprint(out, "END OF RESP\n\n")
+			logEvent("RESP:\n%s\n\n", e.Message.Content)
+		case agent.ToolCallStartedEvent:
+			fmt.Fprintf(out, "TOOL CALL: %s %s\n", e.Call.Function.Name, e.Call.Function.Arguments)
+		case agent.ToolCallFinishedEvent:
+			if e.Err != nil {
+				fmt.Fprintf(out, "TOOL CALL FAILED: %v\n", e.Err)
+			}
+			logEvent("TOOL CALL:\n%s\n", e.Result)
+		case agent.ErrorEvent:
+			if e.Retry != 0 {
+				fmt.Fprintf(out, "\nREQUEST FAILED: %s, retry %d in %s\n", e.Message, e.Retry, e.Delay)
+			} else {
+				fmt.Fprintf(out, "\nREQUEST FAILED: %s\n", e.Message)
+			}
+		case agent.TaskFinishedEvent:
+			fmt.Fprintf(out, "TOKEN USAGE: %s\n", e.Outcome.Usage)
+			fmt.Fprintf(out, "TASK OUTCOME: %s\n", e.Outcome)
+		}
+	}
+}
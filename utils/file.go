@@ -90,3 +90,44 @@ func (fc *FileContent) WriteContent(buf *bytes.Buffer, filepath string) error {
 	}
 	return nil
 }
+
+// ReadRange reads the lines in range r of the file, EndLine is exclusive.
+func ReadRange(path string, r Range) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(file)
+	lineNum := uint(0)
+	for scanner.Scan() {
+		lineNum++
+		if lineNum < r.StartLine {
+			continue
+		}
+		if lineNum >= r.EndLine {
+			break
+		}
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+	}
+	return buf.String(), scanner.Err()
+}
+
+// TextLineCount returns the number of lines in the file, it fails for binary files.
+func TextLineCount(path string) (uint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+		return 0, fmt.Errorf("%s is a binary file", path)
+	}
+	count := uint(bytes.Count(data, []byte{'\n'}))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		count++
+	}
+	return count, nil
+}
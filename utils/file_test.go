@@ -3,6 +3,8 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +16,30 @@ func TestFileContent_WriteContent(t *testing.T) {
 		fmt.Print(buf.String())
 	})
 }
+
+func TestTextLineCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint
+		wantErr bool
+	}{
+		{name: "empty", content: "", want: 0},
+		{name: "trailing newline", content: "a\nb\n", want: 2},
+		{name: "no trailing newline", content: "a\nb", want: 2},
+		{name: "binary", content: "a\x00b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			os.WriteFile(path, []byte(tt.content), 0644)
+			got, err := TextLineCount(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TextLineCount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TextLineCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
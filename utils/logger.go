@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -12,6 +13,11 @@ import (
 var MyInt int
 
 func init() {
+	SetLogOutput(os.Stdout)
+}
+
+// SetLogOutput writes the log to out, e.g. stderr when stdout carries a protocol.
+func SetLogOutput(out io.Writer) {
 	wd, err := os.Getwd()
 	if err != nil {
 		wd = ""
@@ -19,7 +25,7 @@ func init() {
 	}
 
 	consoleWriter := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        out,
 		TimeFormat: "15:04:05", // Custom time format (e.g., HH:mm:ss)
 		// FormatLevel: func(i interface{}) string {
 		// 	return strings.ToUpper(fmt.Sprintf("[%s]", i))
@@ -40,6 +46,4 @@ func init() {
 		Timestamp().
 		Caller().
 		Logger()
-	log.Info().Msg("init logger")
-
 }
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"unicode"
+)
+
+type TokenCounter interface {
+	Count(text string) int
+}
+
+// EstimateCounter estimates the token count of text the way a tiktoken cl100k style BPE
+// tokenizer splits it, without loading the vocabulary. Text is split into words, numbers,
+// punctuation and whitespace pieces first, then every piece is charged by its length.
+type EstimateCounter struct{}
+
+func (EstimateCounter) Count(text string) int {
+	return EstimateTokens(text)
+}
+
+func EstimateTokens(text string) int {
+	runes := []rune(text)
+	count := 0
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.IsLetter(r):
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			// common words are one token, longer identifiers split every ~4 characters
+			count += max(1, (j-i+1)/4)
+		case unicode.IsDigit(r):
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			// numbers are split into groups of at most 3 digits
+			count += (j - i + 2) / 3
+		case r == '\n':
+			for j < len(runes) && runes[j] == '\n' {
+				j++
+			}
+			count++
+		case unicode.IsSpace(r):
+			for j < len(runes) && unicode.IsSpace(runes[j]) && runes[j] != '\n' {
+				j++
+			}
+			// a single space is merged into the following piece
+			if j-i > 1 || j == len(runes) {
+				count += max(1, (j-i)/4)
+			}
+		default:
+			for j < len(runes) && isPunct(runes[j]) {
+				j++
+			}
+			// punctuation is merged in pairs, e.g. ":=", "()", "},"
+			count += (j - i + 1) / 2
+		}
+		i = j
+	}
+	return count
+}
+
+func isPunct(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "words", text: "hello world", want: 2},
+		{name: "code", text: "func main() {\n}\n", want: 7},
+		{name: "long number", text: "1234567", want: 3},
+		{name: "indentation", text: "\t\tx", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}